@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+
+	"github.com/smartcontractkit/chainlink-feeds/median"
+)
+
+const backtestUsage = `Usage: chainlink-feeds backtest -input FILE (-definition JSON | -definition-file FILE) [flags]
+
+Replays a time series through a deviation function definition and reports how
+often the feed would have updated. The input is CSV with a header row, or JSON
+lines, with the fields timestamp (unix seconds or RFC 3339), value, and
+optionally gasPriceSubunits and juelsPerFeeCoin.
+
+Flags:
+`
+
+// runBacktest implements the backtest subcommand and returns the process exit code.
+func runBacktest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, backtestUsage)
+		fs.PrintDefaults()
+	}
+	input := fs.String("input", "", "time series file, .csv or .jsonl")
+	format := fs.String("format", "", "input format, csv or jsonl (default from the file extension)")
+	definition := fs.String("definition", "", "deviation function definition as JSON")
+	definitionFile := fs.String("definition-file", "", "file containing the deviation function definition")
+	thresholdPPB := fs.Uint64("threshold-ppb", 0, "deviation threshold in parts per billion, as in the on-chain config")
+	heartbeat := fs.Duration("heartbeat", 0, "maximum age of the on-chain value before an update is forced, 0 to disable")
+	decimals := fs.Uint("decimals", 0, "decimals of the feed; values in the input are scaled by 10^decimals")
+	gasPerUpdate := fs.Uint64("gas-per-update", 0, "gas used by one transmission, to estimate the gas cost of updates")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	fail := func(err error) int {
+		fmt.Fprintln(stderr, "backtest:", err)
+		return 1
+	}
+	if *input == "" || (*definition == "") == (*definitionFile == "") {
+		fs.Usage()
+		return 2
+	}
+	if *decimals > median.MaxDecimals {
+		return fail(fmt.Errorf("decimals must be at most %d", median.MaxDecimals))
+	}
+
+	def := []byte(*definition)
+	if *definitionFile != "" {
+		var err error
+		if def, err = os.ReadFile(*definitionFile); err != nil {
+			return fail(err)
+		}
+	}
+	var opts map[string]any
+	if err := json.Unmarshal(def, &opts); err != nil {
+		return fail(fmt.Errorf("invalid definition: %w", err))
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*input), ".")
+	}
+	f, err := os.Open(*input)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(*decimals)), nil)
+	var points []median.BacktestPoint
+	switch *format {
+	case "csv":
+		points, err = readCSVSeries(f, unit)
+	case "jsonl":
+		points, err = readJSONLSeries(f, unit)
+	default:
+		err = fmt.Errorf("unsupported input format %q, use csv or jsonl", *format)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result, err := median.Backtest(logger.Nop(), median.BacktestConfig{
+		Definition:   opts,
+		ThresholdPPB: *thresholdPPB,
+		Heartbeat:    *heartbeat,
+		GasPerUpdate: *gasPerUpdate,
+	}, points)
+	if err != nil {
+		return fail(err)
+	}
+
+	if *jsonOutput {
+		out := map[string]any{
+			"points":           result.Points,
+			"updates":          result.Updates,
+			"deviationUpdates": result.DeviationUpdates,
+			"heartbeatUpdates": result.HeartbeatUpdates,
+			"maxStaleness":     result.MaxStaleness.String(),
+			"maxError":         result.MaxError.String(),
+			"maxErrorPPB":      result.MaxErrorPPB.String(),
+		}
+		if result.EstimatedGasCost != nil {
+			out["estimatedGasCost"] = result.EstimatedGasCost.String()
+		}
+		if *gasPerUpdate > 0 {
+			out["unpricedUpdates"] = result.UnpricedUpdates
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return fail(err)
+		}
+		return 0
+	}
+	fmt.Fprintf(stdout, "points:             %d\n", result.Points)
+	fmt.Fprintf(stdout, "updates:            %d (deviation: %d, heartbeat: %d)\n", result.Updates, result.DeviationUpdates, result.HeartbeatUpdates)
+	fmt.Fprintf(stdout, "max staleness:      %s\n", result.MaxStaleness)
+	fmt.Fprintf(stdout, "max error:          %s (%s ppb)\n", result.MaxError, result.MaxErrorPPB)
+	if result.EstimatedGasCost != nil {
+		fmt.Fprintf(stdout, "estimated gas cost: %s\n", result.EstimatedGasCost)
+	}
+	if result.UnpricedUpdates > 0 {
+		fmt.Fprintf(stdout, "unpriced updates:   %d (no gas price, not in the estimated gas cost)\n", result.UnpricedUpdates)
+	}
+	return 0
+}
+
+// seriesRecord is one row of the input, with every field as found in the file.
+type seriesRecord struct {
+	timestamp, value, gasPriceSubunits, juelsPerFeeCoin string
+}
+
+func readCSVSeries(r io.Reader, unit *big.Int) ([]median.BacktestPoint, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"timestamp", "value"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var points []median.BacktestPoint
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p, err := parseSeriesRecord(seriesRecord{field(row, "timestamp"), field(row, "value"), field(row, "gasPriceSubunits"), field(row, "juelsPerFeeCoin")}, unit)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		points = append(points, p)
+	}
+}
+
+func readJSONLSeries(r io.Reader, unit *big.Int) ([]median.BacktestPoint, error) {
+	var points []median.BacktestPoint
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		// Fields may be JSON strings or numbers, numbers are taken verbatim to keep their precision.
+		field := func(name string) string {
+			v := strings.TrimSpace(string(raw[name]))
+			if s, err := strconv.Unquote(v); err == nil {
+				return s
+			}
+			return v
+		}
+		p, err := parseSeriesRecord(seriesRecord{field("timestamp"), field("value"), field("gasPriceSubunits"), field("juelsPerFeeCoin")}, unit)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		points = append(points, p)
+	}
+	return points, scanner.Err()
+}
+
+func parseSeriesRecord(rec seriesRecord, unit *big.Int) (median.BacktestPoint, error) {
+	var p median.BacktestPoint
+	var err error
+	if p.Time, err = parseSeriesTime(rec.timestamp); err != nil {
+		return p, err
+	}
+	if p.Value, err = parseScaledValue(rec.value, unit); err != nil {
+		return p, err
+	}
+	if rec.gasPriceSubunits != "" {
+		if p.GasPriceSubunits, err = parseInteger("gasPriceSubunits", rec.gasPriceSubunits); err != nil {
+			return p, err
+		}
+	}
+	if rec.juelsPerFeeCoin != "" {
+		if p.JuelsPerFeeCoin, err = parseInteger("juelsPerFeeCoin", rec.juelsPerFeeCoin); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func parseSeriesTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing timestamp")
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	seconds, ok := median.ParseDecimal(s, 30)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	nanos := new(big.Rat).Mul(seconds, big.NewRat(1e9, 1))
+	n := new(big.Int).Quo(nanos.Num(), nanos.Denom())
+	if !n.IsInt64() {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Unix(0, n.Int64()), nil
+}
+
+// parseScaledValue parses a decimal value and scales it by unit, which must give an integer.
+func parseScaledValue(s string, unit *big.Int) (*big.Int, error) {
+	v, ok := median.ParseDecimal(s, median.MaxDecimalStringLength)
+	if !ok {
+		return nil, fmt.Errorf("invalid value %q", s)
+	}
+	v.Mul(v, new(big.Rat).SetInt(unit))
+	if !v.IsInt() {
+		return nil, fmt.Errorf("value %q has more digits than -decimals allows", s)
+	}
+	return new(big.Int).Set(v.Num()), nil
+}
+
+func parseInteger(name, s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok || len(s) > 100 || v.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return v, nil
+}
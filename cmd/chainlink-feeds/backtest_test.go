@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-feeds/median"
+)
+
+func Test_readCSVSeries(t *testing.T) {
+	tcs := []struct {
+		name  string
+		input string
+
+		err      string
+		expected []median.BacktestPoint
+	}{
+		{
+			name:  "all columns in any order",
+			input: "value, timestamp, juelsPerFeeCoin, gasPriceSubunits\n1.5, 100, 7, 30\n2, 2024-01-01T00:00:00Z,,\n",
+			expected: []median.BacktestPoint{
+				{Time: time.Unix(100, 0), Value: big.NewInt(150), GasPriceSubunits: big.NewInt(30), JuelsPerFeeCoin: big.NewInt(7)},
+				{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Value: big.NewInt(200)},
+			},
+		},
+		{name: "header only", input: "timestamp,value\n"},
+		{name: "empty input", input: "", err: "reading header: EOF"},
+		{name: "missing value column", input: "timestamp,price\n1,2\n", err: `missing "value" column`},
+		{name: "short row", input: "timestamp,value\n100,1\n101\n", err: "record on line 3: wrong number of fields"},
+		{name: "invalid value", input: "timestamp,value\n100,1\n101,abc\n", err: `line 3: invalid value "abc"`},
+		{name: "invalid timestamp", input: "timestamp,value\nyesterday,1\n", err: `line 2: invalid timestamp "yesterday"`},
+		{name: "missing timestamp", input: "timestamp,value\n,1\n", err: "line 2: missing timestamp"},
+		{name: "negative gas price", input: "timestamp,value,gasPriceSubunits\n100,1,-1\n", err: `line 2: invalid gasPriceSubunits "-1"`},
+		{name: "too many decimals", input: "timestamp,value\n100,1.234\n", err: `line 2: value "1.234" has more digits than -decimals allows`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			points, err := readCSVSeries(strings.NewReader(tc.input), big.NewInt(100))
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assertPoints(t, tc.expected, points)
+		})
+	}
+}
+
+func Test_readJSONLSeries(t *testing.T) {
+	tcs := []struct {
+		name  string
+		input string
+
+		err      string
+		expected []median.BacktestPoint
+	}{
+		{
+			name:  "numbers and strings, blank lines skipped",
+			input: `{"timestamp": 100, "value": 1.5, "gasPriceSubunits": "30"}` + "\n\n" + `{"timestamp": "2024-01-01T00:00:00Z", "value": "2", "juelsPerFeeCoin": 7}` + "\n",
+			expected: []median.BacktestPoint{
+				{Time: time.Unix(100, 0), Value: big.NewInt(150), GasPriceSubunits: big.NewInt(30)},
+				{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Value: big.NewInt(200), JuelsPerFeeCoin: big.NewInt(7)},
+			},
+		},
+		{
+			name:     "numbers keep their precision",
+			input:    `{"timestamp": 100, "value": 12345678901234567890.12}`,
+			expected: []median.BacktestPoint{{Time: time.Unix(100, 0), Value: valueOf(t, "1234567890123456789012")}},
+		},
+		{name: "empty input"},
+		{name: "not JSON", input: `{"timestamp": 100, "value": 1}` + "\n" + `timestamp=101`, err: "line 2: invalid character 'i' in literal true (expecting 'r')"},
+		{name: "missing value", input: `{"timestamp": 100}`, err: `line 1: invalid value ""`},
+		{name: "value with exponent", input: `{"timestamp": 100, "value": 1e3}`, err: `line 1: invalid value "1e3"`},
+		{name: "fractional juels", input: `{"timestamp": 100, "value": 1, "juelsPerFeeCoin": 1.5}`, err: `line 1: invalid juelsPerFeeCoin "1.5"`},
+		{name: "null timestamp", input: `{"timestamp": null, "value": 1}`, err: `line 1: invalid timestamp "null"`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			points, err := readJSONLSeries(strings.NewReader(tc.input), big.NewInt(100))
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assertPoints(t, tc.expected, points)
+		})
+	}
+}
+
+func Test_parseSeriesTime(t *testing.T) {
+	tcs := []struct {
+		input    string
+		err      string
+		expected time.Time
+	}{
+		{input: "1700000000", expected: time.Unix(1700000000, 0)},
+		{input: "1700000000.25", expected: time.Unix(1700000000, 250_000_000)},
+		{input: "-1", expected: time.Unix(-1, 0)},
+		{input: "2024-03-10T17:30:00-04:00", expected: time.Date(2024, 3, 10, 21, 30, 0, 0, time.UTC)},
+		{input: "2024-03-10T17:30:00.5Z", expected: time.Date(2024, 3, 10, 17, 30, 0, 500_000_000, time.UTC)},
+		{input: "", err: "missing timestamp"},
+		{input: "2024-03-10", err: `invalid timestamp "2024-03-10"`},
+		{input: "1e9", err: `invalid timestamp "1e9"`},
+		{input: "1.", err: `invalid timestamp "1."`},
+		{input: "+1", err: `invalid timestamp "+1"`},
+		{input: "99999999999999999999", err: `invalid timestamp "99999999999999999999"`},
+		{input: strings.Repeat("1", 31), err: `invalid timestamp "` + strings.Repeat("1", 31) + `"`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.input, func(t *testing.T) {
+			actual, err := parseSeriesTime(tc.input)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tc.expected.Equal(actual), "expected %s, got %s", tc.expected, actual)
+		})
+	}
+}
+
+func Test_parseScaledValue(t *testing.T) {
+	tcs := []struct {
+		input    string
+		decimals int64
+		err      string
+		expected string
+	}{
+		{input: "1", decimals: 0, expected: "1"},
+		{input: "1.5", decimals: 8, expected: "150000000"},
+		{input: "-0.000001", decimals: 6, expected: "-1"},
+		{input: "0.100", decimals: 1, expected: "1"},
+		{input: "1.25", decimals: 1, err: `value "1.25" has more digits than -decimals allows`},
+		{input: "", err: `invalid value ""`},
+		{input: "1e18", err: `invalid value "1e18"`},
+		{input: "0x10", err: `invalid value "0x10"`},
+		{input: "1/2", err: `invalid value "1/2"`},
+		{input: " 1", err: `invalid value " 1"`},
+		{input: strings.Repeat("9", 101), err: `invalid value "` + strings.Repeat("9", 101) + `"`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.input, func(t *testing.T) {
+			unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(tc.decimals), nil)
+			actual, err := parseScaledValue(tc.input, unit)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual.String())
+		})
+	}
+}
+
+func Test_runBacktest(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "series.csv")
+	require.NoError(t, os.WriteFile(input, []byte("timestamp,value,gasPriceSubunits\n100,1,10\n200,2,\n300,3,10\n"), 0o600))
+	definition := `{"type": "relative", "zeroPolicy": "alwaysUpdate"}`
+
+	t.Run("reports updates without a gas price", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runBacktest([]string{"-input", input, "-definition", definition, "-threshold-ppb", "10000000", "-gas-per-update", "100"}, &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+		assert.Contains(t, stdout.String(), "updates:            3 (deviation: 2, heartbeat: 0)\n")
+		assert.Contains(t, stdout.String(), "estimated gas cost: 2000\n")
+		assert.Contains(t, stdout.String(), "unpriced updates:   1 (no gas price, not in the estimated gas cost)\n")
+	})
+	t.Run("json output", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runBacktest([]string{"-input", input, "-definition", definition, "-threshold-ppb", "10000000", "-gas-per-update", "100", "-json"}, &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+		assert.Contains(t, stdout.String(), `"estimatedGasCost": "2000"`)
+		assert.Contains(t, stdout.String(), `"unpricedUpdates": 1`)
+	})
+	t.Run("malformed input", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.jsonl")
+		require.NoError(t, os.WriteFile(bad, []byte(`{"timestamp": 100, "value": "one"}`), 0o600))
+		var stdout, stderr bytes.Buffer
+		code := runBacktest([]string{"-input", bad, "-definition", definition}, &stdout, &stderr)
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "backtest: line 1: invalid value \"one\"\n", stderr.String())
+	})
+	t.Run("missing definition", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runBacktest([]string{"-input", input}, &stdout, &stderr)
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr.String(), "Usage: chainlink-feeds backtest")
+	})
+}
+
+func assertPoints(t *testing.T, expected, actual []median.BacktestPoint) {
+	t.Helper()
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.True(t, expected[i].Time.Equal(actual[i].Time), "point %d: expected time %s, got %s", i, expected[i].Time, actual[i].Time)
+		assert.Equal(t, expected[i].Value, actual[i].Value, "point %d value", i)
+		assert.Equal(t, expected[i].GasPriceSubunits, actual[i].GasPriceSubunits, "point %d gas price", i)
+		assert.Equal(t, expected[i].JuelsPerFeeCoin, actual[i].JuelsPerFeeCoin, "point %d juels", i)
+	}
+}
+
+func valueOf(t *testing.T, s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	require.True(t, ok)
+	return v
+}
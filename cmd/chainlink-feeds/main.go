@@ -1,6 +1,10 @@
 package main
 
 import (
+	"net/http"
+	"os"
+	"strconv"
+
 	"github.com/hashicorp/go-plugin"
 
 	"github.com/smartcontractkit/chainlink-common/pkg/loop"
@@ -10,14 +14,45 @@ import (
 
 const (
 	loggerName = "PluginMedian"
+	// decisionLogEnv names a file deviation decisions are appended to as JSON lines.
+	decisionLogEnv = "CL_MEDIAN_DECISION_LOG"
+	// decisionHistoryEnv sets the number of deviation decisions kept per feed.
+	decisionHistoryEnv = "CL_MEDIAN_DECISION_HISTORY"
+	// decisionsPath serves the kept deviation decisions. The prometheus server
+	// of the LOOP serves http.DefaultServeMux, so the path is reachable on the
+	// port the node assigned for metrics.
+	decisionsPath = "/debug/median/decisions"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "backtest" {
+		os.Exit(runBacktest(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	s := loop.MustNewStartedServer(loggerName)
 	defer s.Stop()
 
-	p := median.NewPlugin(s.Logger)
+	var opts []median.PluginOption
+	if path := os.Getenv(decisionLogEnv); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			s.Logger.Fatalw("Failed to open deviation decision log", "path", path, "err", err)
+		}
+		defer f.Close()
+		opts = append(opts, median.WithDecisionSink(median.NewJSONLDecisionSink(s.Logger, f)))
+	}
+
+	if v := os.Getenv(decisionHistoryEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.Logger.Fatalw("Invalid deviation decision history", "env", decisionHistoryEnv, "value", v)
+		}
+		opts = append(opts, median.WithDecisionHistory(n))
+	}
+
+	p := median.NewPlugin(s.Logger, opts...)
 	defer s.Logger.ErrorIfFn(p.Close, "Failed to close")
+	http.Handle(decisionsPath, p.DecisionsHandler())
 
 	s.MustRegister(p)
 
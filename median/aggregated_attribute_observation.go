@@ -2,6 +2,10 @@ package median
 
 import (
 	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
 	"math/big"
 	"slices"
 
@@ -9,25 +13,165 @@ import (
 	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
 )
 
+// aggregatedAttributedObservation is the version 1 report layout, limited to
+// maxObserversV1 observers by its fixed size Observers array. It has no room for
+// the observers rejected as outliers, later versions carry them.
 type aggregatedAttributedObservation struct {
 	Timestamp       uint32
-	Observers       [32]commontypes.OracleID
+	Observers       [maxObserversV1]commontypes.OracleID
 	Observations    []*big.Int
 	JuelsPerFeeCoin *big.Int
 	GasPriceSubunit *big.Int
 }
 
-func aggregate(observations []median.ParsedAttributedObservation) *aggregatedAttributedObservation {
+const maxObserversV1 = 32
+
+// aggregatedAttributedObservationV2 is the version 2 report layout. It starts
+// with its version, so consumers can tell it apart from version 1, and has one
+// observer per observation without limit.
+type aggregatedAttributedObservationV2 struct {
+	Version         uint8
+	Timestamp       uint32
+	Observers       []commontypes.OracleID
+	Observations    []*big.Int
+	JuelsPerFeeCoin *big.Int
+	GasPriceSubunit *big.Int
+	// RejectedObservers made the observations dropped as outliers, in ascending order.
+	RejectedObservers []commontypes.OracleID
+}
+
+// aggregatedAttributedObservationV3 is the version 3 report layout, version 2
+// followed by statistics on how much the contributing oracles agreed. It is
+// also the form reports are built and read in, whatever their version.
+type aggregatedAttributedObservationV3 struct {
+	Version         uint8
+	Timestamp       uint32
+	Observers       []commontypes.OracleID
+	Observations    []*big.Int
+	JuelsPerFeeCoin *big.Int
+	GasPriceSubunit *big.Int
+	// RejectedObservers made the observations dropped as outliers, in ascending order.
+	RejectedObservers []commontypes.OracleID
+	// Spread is the largest minus the smallest observation.
+	Spread *big.Int
+	// InterquartileRange is the observation at index 3n/4 minus the one at index n/4.
+	InterquartileRange *big.Int
+	// Contributors is the number of observations the report holds.
+	Contributors uint32
+}
+
+// toV1 converts a to the version 1 layout, which fails for more than maxObserversV1
+// observations or if observers were rejected as outliers, since it cannot record
+// them. The statistics are dropped.
+func (a *aggregatedAttributedObservationV3) toV1() (*aggregatedAttributedObservation, error) {
+	if len(a.Observations) > maxObserversV1 {
+		return nil, fmt.Errorf("report version 1 supports at most %d observations, got %d", maxObserversV1, len(a.Observations))
+	}
+	if len(a.RejectedObservers) > 0 {
+		return nil, fmt.Errorf("report version 1 cannot record rejected observers, got %d", len(a.RejectedObservers))
+	}
+	v1 := &aggregatedAttributedObservation{
+		Timestamp:       a.Timestamp,
+		Observations:    a.Observations,
+		JuelsPerFeeCoin: a.JuelsPerFeeCoin,
+		GasPriceSubunit: a.GasPriceSubunit,
+	}
+	copy(v1.Observers[:], a.Observers)
+	return v1, nil
+}
+
+// toV2 converts a to the version 2 layout, dropping its statistics.
+func (a *aggregatedAttributedObservationV3) toV2() *aggregatedAttributedObservationV2 {
+	return &aggregatedAttributedObservationV2{
+		Version:           reportVersion2,
+		Timestamp:         a.Timestamp,
+		Observers:         a.Observers,
+		Observations:      a.Observations,
+		JuelsPerFeeCoin:   a.JuelsPerFeeCoin,
+		GasPriceSubunit:   a.GasPriceSubunit,
+		RejectedObservers: a.RejectedObservers,
+	}
+}
+
+// toV3 converts a decoded version 1 report to the version 3 layout, with statistics computed from its observations.
+func (a *aggregatedAttributedObservation) toV3() (*aggregatedAttributedObservationV3, error) {
+	if len(a.Observations) > maxObserversV1 {
+		return nil, fmt.Errorf("report version 1 supports at most %d observations, got %d", maxObserversV1, len(a.Observations))
+	}
+	v3 := &aggregatedAttributedObservationV3{
+		Version:         reportVersion1,
+		Timestamp:       a.Timestamp,
+		Observers:       slices.Clone(a.Observers[:len(a.Observations)]),
+		Observations:    a.Observations,
+		JuelsPerFeeCoin: a.JuelsPerFeeCoin,
+		GasPriceSubunit: a.GasPriceSubunit,
+	}
+	v3.setDispersion()
+	return v3, nil
+}
+
+// toV3 converts a decoded version 2 report to the version 3 layout, with statistics computed from its observations.
+func (a *aggregatedAttributedObservationV2) toV3() *aggregatedAttributedObservationV3 {
+	v3 := &aggregatedAttributedObservationV3{
+		Version:           a.Version,
+		Timestamp:         a.Timestamp,
+		Observers:         a.Observers,
+		Observations:      a.Observations,
+		JuelsPerFeeCoin:   a.JuelsPerFeeCoin,
+		GasPriceSubunit:   a.GasPriceSubunit,
+		RejectedObservers: a.RejectedObservers,
+	}
+	v3.setDispersion()
+	return v3
+}
+
+// setDispersion computes the statistics of a from its observations, which are sorted ascending.
+func (a *aggregatedAttributedObservationV3) setDispersion() {
+	n := len(a.Observations)
+	a.Contributors = uint32(n)
+	if n == 0 {
+		a.Spread, a.InterquartileRange = new(big.Int), new(big.Int)
+		return
+	}
+	a.Spread = new(big.Int).Sub(a.Observations[n-1], a.Observations[0])
+	a.InterquartileRange = new(big.Int).Sub(a.Observations[3*n/4], a.Observations[n/4])
+}
+
+// outlierFilterKey is the field of the factory configuration enabling outlier
+// rejection. It is removed before the rest of the configuration is read as a
+// deviation function definition.
+const outlierFilterKey = "outlierFilter"
+
+// MaxOutlierK bounds the 'k' field of the outlier filter.
+const MaxOutlierK = 1000
+
+// MaxOutlierMinTolerancePPB bounds the 'minTolerancePPB' field of the outlier filter, 100% of the median.
+const MaxOutlierMinTolerancePPB = 1e9
+
+// outlierFilter rejects observations further than k median absolute deviations
+// from the median of all observations.
+type outlierFilter struct {
+	k *big.Rat
+	// minTolerancePPB is how far from the median, in parts per billion of it, an
+	// observation is always kept, 0 if unset. It gives a scale when the MAD is zero.
+	minTolerancePPB uint64
+	// minObservations is the number of observations that must survive, a majority if zero
+	minObservations int
+}
+
+// aggregate builds a report in the version 3 layout, see toV1 and toV2 for the older ones.
+// Outliers may be nil to keep all observations, timestamps nil to take the median timestamp.
+func aggregate(observations []median.ParsedAttributedObservation, outliers *outlierFilter, timestamps *timestampPolicy) (*aggregatedAttributedObservationV3, error) {
 	// defensive copy
 	n := len(observations)
 	observations = slices.Clone(observations)
 
-	aggregated := &aggregatedAttributedObservation{Observations: make([]*big.Int, len(observations))}
+	aggregated := &aggregatedAttributedObservationV3{Version: reportVersion3}
 
 	slices.SortFunc(observations, func(a, b median.ParsedAttributedObservation) int {
 		return cmp.Compare(a.Timestamp, b.Timestamp)
 	})
-	aggregated.Timestamp = observations[n/2].Timestamp
+	aggregated.Timestamp = timestamps.pick(observations)
 
 	slices.SortFunc(observations, func(a, b median.ParsedAttributedObservation) int {
 		return a.JuelsPerFeeCoin.Cmp(b.JuelsPerFeeCoin)
@@ -43,9 +187,116 @@ func aggregate(observations []median.ParsedAttributedObservation) *aggregatedAtt
 		return a.Value.Cmp(b.Value)
 	})
 
+	if outliers != nil {
+		var rejected []median.ParsedAttributedObservation
+		observations, rejected = outliers.split(observations)
+		for _, o := range rejected {
+			aggregated.RejectedObservers = append(aggregated.RejectedObservers, o.Observer)
+		}
+		slices.Sort(aggregated.RejectedObservers)
+		minObservations := outliers.minObservations
+		if minObservations == 0 {
+			minObservations = n/2 + 1
+		}
+		if len(observations) < minObservations {
+			return nil, fmt.Errorf("only %d of %d observations are not outliers, need at least %d", len(observations), n, minObservations)
+		}
+	}
+
+	aggregated.Observers = make([]commontypes.OracleID, len(observations))
+	aggregated.Observations = make([]*big.Int, len(observations))
 	for i, o := range observations {
 		aggregated.Observers[i] = o.Observer
 		aggregated.Observations[i] = o.Value
 	}
-	return aggregated
+	aggregated.setDispersion()
+	return aggregated, nil
+}
+
+// split returns the observations, sorted by value, that are within k median
+// absolute deviations of the upper median, or within minTolerancePPB of it, and
+// the rest. When most observations agree exactly the MAD is zero, so any other
+// value is rejected unless it is within the tolerance.
+func (f *outlierFilter) split(sorted []median.ParsedAttributedObservation) (kept, rejected []median.ParsedAttributedObservation) {
+	center := sorted[len(sorted)/2].Value
+	deviations := make([]*big.Int, len(sorted))
+	for i, o := range sorted {
+		deviations[i] = new(big.Int).Sub(o.Value, center)
+		deviations[i].Abs(deviations[i])
+	}
+	sortedDeviations := slices.Clone(deviations)
+	slices.SortFunc(sortedDeviations, (*big.Int).Cmp)
+	mad := sortedDeviations[len(sortedDeviations)/2]
+
+	limit := new(big.Rat).Mul(f.k, new(big.Rat).SetInt(mad))
+	tolerance := new(big.Rat).SetFrac(new(big.Int).Abs(center), big.NewInt(1e9))
+	tolerance.Mul(tolerance, new(big.Rat).SetInt(new(big.Int).SetUint64(f.minTolerancePPB)))
+	if tolerance.Cmp(limit) > 0 {
+		limit = tolerance
+	}
+	for i, o := range sorted {
+		if new(big.Rat).SetInt(deviations[i]).Cmp(limit) > 0 {
+			rejected = append(rejected, o)
+		} else {
+			kept = append(kept, o)
+		}
+	}
+	return kept, rejected
+}
+
+func newOutlierFilter(opts map[string]any) (*outlierFilter, error) {
+	k, ok := opts["k"].(float64)
+	if !ok || math.IsNaN(k) || k <= 0 || k > MaxOutlierK {
+		return nil, fmt.Errorf("missing or invalid 'k' field in outlier filter definition, must be a number above 0 and at most %d", MaxOutlierK)
+	}
+	f := &outlierFilter{k: new(big.Rat).SetFloat64(k)}
+	if _, ok := opts["minTolerancePPB"]; ok {
+		v, err := integerOption(opts, "minTolerancePPB")
+		if err != nil || v > MaxOutlierMinTolerancePPB {
+			return nil, fmt.Errorf("missing or invalid 'minTolerancePPB' field in outlier filter definition, must be an integer between 0 and %d", uint64(MaxOutlierMinTolerancePPB))
+		}
+		f.minTolerancePPB = v
+	}
+	if _, ok := opts["minObservations"]; ok {
+		v, err := integerOption(opts, "minObservations")
+		if err != nil || v == 0 || v > maxSafeJSONInteger {
+			return nil, errors.New("missing or invalid 'minObservations' field in outlier filter definition, must be a positive integer")
+		}
+		f.minObservations = int(v)
+	}
+	for _, key := range slices.Sorted(maps.Keys(opts)) {
+		if key != "k" && key != "minTolerancePPB" && key != "minObservations" {
+			return nil, fmt.Errorf("unknown field '%s' in outlier filter definition", key)
+		}
+	}
+	return f, nil
+}
+
+// checkOutlierFilterVersion returns an error if outliers is set and reports of
+// version cannot record the observers it rejects, which takes version 2 or later.
+func checkOutlierFilterVersion(outliers *outlierFilter, version uint8) error {
+	if outliers != nil && version < reportVersion2 {
+		return fmt.Errorf("an outlier filter requires report version %d or later to record the rejected observers, got %d", reportVersion2, version)
+	}
+	return nil
+}
+
+// splitOutlierFilterConfig returns the outlier filter enabled by the factory
+// configuration cfg, nil if none is, and cfg without it.
+func splitOutlierFilterConfig(cfg map[string]any) (*outlierFilter, map[string]any, error) {
+	raw, ok := cfg[outlierFilterKey]
+	if !ok {
+		return nil, cfg, nil
+	}
+	rest := maps.Clone(cfg)
+	delete(rest, outlierFilterKey)
+	opts, ok := raw.(map[string]any)
+	if !ok {
+		return nil, nil, errors.New("missing or invalid 'outlierFilter' field in factory configuration")
+	}
+	f, err := newOutlierFilter(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, rest, nil
 }
@@ -0,0 +1,236 @@
+package median
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_aggregate_Outliers(t *testing.T) {
+	observe := func(values ...int64) []median.ParsedAttributedObservation {
+		paos := make([]median.ParsedAttributedObservation, len(values))
+		for i, v := range values {
+			paos[i] = median.ParsedAttributedObservation{
+				Timestamp:        uint32(100 + i),
+				Value:            big.NewInt(v),
+				JuelsPerFeeCoin:  big.NewInt(1),
+				GasPriceSubunits: big.NewInt(1),
+				Observer:         commontypes.OracleID(i),
+			}
+		}
+		return paos
+	}
+	tcs := []struct {
+		name         string
+		observations []median.ParsedAttributedObservation
+		outliers     *outlierFilter
+
+		err       string
+		values    []int64
+		observers []commontypes.OracleID
+		rejected  []commontypes.OracleID
+	}{
+		{
+			name:         "no filter keeps everything",
+			observations: observe(100, 101, 99, 1_000_000),
+			values:       []int64{99, 100, 101, 1_000_000},
+			observers:    []commontypes.OracleID{2, 0, 1, 3},
+		},
+		{
+			name:         "rejects a wild value",
+			observations: observe(100, 101, 99, 102, 1_000_000),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1)},
+			values:       []int64{99, 100, 101, 102},
+			observers:    []commontypes.OracleID{2, 0, 1, 3},
+			rejected:     []commontypes.OracleID{4},
+		},
+		{
+			name:         "rejects on both sides, observers in ascending order",
+			observations: observe(-1_000_000, 100, 101, 99, 102, 1_000_000),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1)},
+			values:       []int64{99, 100, 101, 102},
+			observers:    []commontypes.OracleID{3, 1, 2, 4},
+			rejected:     []commontypes.OracleID{0, 5},
+		},
+		{
+			// median 101, deviations 2, 1, 0, 1, 7 give a MAD of 1
+			name:         "value at exactly k deviations is kept",
+			observations: observe(99, 100, 101, 102, 108),
+			outliers:     &outlierFilter{k: big.NewRat(7, 1)},
+			values:       []int64{99, 100, 101, 102, 108},
+			observers:    []commontypes.OracleID{0, 1, 2, 3, 4},
+		},
+		{
+			name:         "zero MAD still rejects a wild value",
+			observations: observe(1000, 1000, 1000, 5),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1)},
+			values:       []int64{1000, 1000, 1000},
+			observers:    []commontypes.OracleID{0, 1, 2},
+			rejected:     []commontypes.OracleID{3},
+		},
+		{
+			name:         "zero MAD without a tolerance rejects any other value",
+			observations: observe(1000, 1000, 1000, 1001),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1)},
+			values:       []int64{1000, 1000, 1000},
+			observers:    []commontypes.OracleID{0, 1, 2},
+			rejected:     []commontypes.OracleID{3},
+		},
+		{
+			// 1% of 1000 is 10
+			name:         "zero MAD keeps values within the tolerance",
+			observations: observe(1000, 1000, 1000, 1010, 989),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1), minTolerancePPB: 1e7},
+			values:       []int64{1000, 1000, 1000, 1010},
+			observers:    []commontypes.OracleID{0, 1, 2, 3},
+			rejected:     []commontypes.OracleID{4},
+		},
+		{
+			// median 101, MAD 1, so k alone would reject 108
+			name:         "tolerance above k deviations keeps a value",
+			observations: observe(99, 100, 101, 102, 108),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1), minTolerancePPB: 1e8},
+			values:       []int64{99, 100, 101, 102, 108},
+			observers:    []commontypes.OracleID{0, 1, 2, 3, 4},
+		},
+		{
+			// median 110, MAD 20, with k 1/2 only 100 and 110 survive
+			name:         "too few survive for the default majority",
+			observations: observe(90, 100, 110, 130, 70, 140),
+			outliers:     &outlierFilter{k: big.NewRat(1, 2)},
+			err:          "only 2 of 6 observations are not outliers, need at least 4",
+		},
+		{
+			name:         "explicit minimum",
+			observations: observe(90, 100, 110, 130, 70, 140),
+			outliers:     &outlierFilter{k: big.NewRat(1, 2), minObservations: 2},
+			values:       []int64{100, 110},
+			observers:    []commontypes.OracleID{1, 2},
+			rejected:     []commontypes.OracleID{0, 3, 4, 5},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			agg, err := aggregate(tc.observations, tc.outliers, nil)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, bigInts(tc.values...), agg.Observations)
+			assert.Equal(t, tc.observers, agg.Observers[:len(agg.Observations)])
+			assert.Equal(t, tc.rejected, agg.RejectedObservers)
+			// The other medians are taken over all observations
+			assert.Equal(t, tc.observations[len(tc.observations)/2].Timestamp, agg.Timestamp)
+		})
+	}
+}
+
+func Test_aggregate_Dispersion(t *testing.T) {
+	observe := func(values ...int64) []median.ParsedAttributedObservation {
+		paos := make([]median.ParsedAttributedObservation, len(values))
+		for i, v := range values {
+			paos[i] = median.ParsedAttributedObservation{
+				Value:            big.NewInt(v),
+				JuelsPerFeeCoin:  big.NewInt(1),
+				GasPriceSubunits: big.NewInt(1),
+				Observer:         commontypes.OracleID(i),
+			}
+		}
+		return paos
+	}
+	tcs := []struct {
+		name         string
+		observations []median.ParsedAttributedObservation
+		outliers     *outlierFilter
+
+		spread       int64
+		iqr          int64
+		contributors uint32
+	}{
+		{name: "single observation", observations: observe(7), contributors: 1},
+		{name: "unsorted", observations: observe(40, 10, 30, 20), spread: 30, iqr: 20, contributors: 4},
+		{name: "negative values", observations: observe(-5, 5, 0, -10, 10), spread: 20, iqr: 10, contributors: 5},
+		{
+			name:         "outliers do not contribute",
+			observations: observe(100, 101, 99, 102, 1_000_000),
+			outliers:     &outlierFilter{k: big.NewRat(3, 1)},
+			spread:       3,
+			iqr:          2,
+			contributors: 4,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			agg, err := aggregate(tc.observations, tc.outliers, nil)
+			require.NoError(t, err)
+			assert.Equal(t, reportVersion3, agg.Version)
+			assert.Equal(t, tc.spread, agg.Spread.Int64())
+			assert.Equal(t, tc.iqr, agg.InterquartileRange.Int64())
+			assert.Equal(t, tc.contributors, agg.Contributors)
+		})
+	}
+}
+
+func Test_newOutlierFilter(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		f, err := newOutlierFilter(map[string]any{"k": 3.5, "minObservations": float64(4)})
+		require.NoError(t, err)
+		assert.Equal(t, big.NewRat(7, 2), f.k)
+		assert.Equal(t, uint64(0), f.minTolerancePPB)
+		assert.Equal(t, 4, f.minObservations)
+
+		f, err = newOutlierFilter(map[string]any{"k": float64(3), "minTolerancePPB": float64(1e7)})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1e7), f.minTolerancePPB)
+	})
+	for name, opts := range map[string]map[string]any{
+		"missing k":      {},
+		"zero k":         {"k": float64(0)},
+		"huge k":         {"k": float64(1e9)},
+		"non-numeric k":  {"k": "3"},
+		"zero minimum":   {"k": float64(3), "minObservations": float64(0)},
+		"huge minimum":   {"k": float64(3), "minObservations": float64(1e300)},
+		"unknown field":  {"k": float64(3), "K": float64(3)},
+		"huge tolerance": {"k": float64(3), "minTolerancePPB": float64(2e9)},
+		"fractional tol": {"k": float64(3), "minTolerancePPB": 0.5},
+		"fractional min": {"k": float64(3), "minObservations": 2.5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newOutlierFilter(opts)
+			require.Error(t, err)
+		})
+	}
+	t.Run("requires report version 2", func(t *testing.T) {
+		f := &outlierFilter{k: big.NewRat(3, 1)}
+		require.EqualError(t, checkOutlierFilterVersion(f, reportVersion1), "an outlier filter requires report version 2 or later to record the rejected observers, got 1")
+		require.NoError(t, checkOutlierFilterVersion(f, reportVersion2))
+		require.NoError(t, checkOutlierFilterVersion(f, reportVersion3))
+		require.NoError(t, checkOutlierFilterVersion(nil, reportVersion1))
+
+		// Rejected before the provider is used
+		_, err := NewPlugin(logger.Test(t)).NewMedianFactory(context.Background(), nil, "feed", nil, nil, nil, nil, map[string]any{"outlierFilter": map[string]any{"k": float64(3)}})
+		require.EqualError(t, err, "failed to create outlier filter: an outlier filter requires report version 2 or later to record the rejected observers, got 1")
+	})
+	t.Run("split from the factory configuration", func(t *testing.T) {
+		f, rest, err := splitOutlierFilterConfig(map[string]any{"type": "relative", "outlierFilter": map[string]any{"k": float64(3)}})
+		require.NoError(t, err)
+		assert.Equal(t, big.NewRat(3, 1), f.k)
+		assert.Equal(t, map[string]any{"type": "relative"}, rest)
+
+		f, rest, err = splitOutlierFilterConfig(map[string]any{"type": "relative"})
+		require.NoError(t, err)
+		assert.Nil(t, f)
+		assert.Equal(t, map[string]any{"type": "relative"}, rest)
+
+		_, _, err = splitOutlierFilterConfig(map[string]any{"outlierFilter": float64(3)})
+		require.EqualError(t, err, "missing or invalid 'outlierFilter' field in factory configuration")
+	})
+}
@@ -0,0 +1,240 @@
+package median
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+)
+
+// aggregatorKey is the field of the factory configuration selecting the
+// [Aggregator] of a feed. It is removed before the rest of the configuration is
+// read as a deviation function definition.
+const aggregatorKey = "aggregator"
+
+// MaxAggregatorPercent bounds the share of observations trimmed or winsorized at each end.
+const MaxAggregatorPercent = 49
+
+// Aggregator computes the value of a report from its observations. Values are
+// sorted ascending and observers[i] made observation values[i]. The same
+// Aggregator must be used to build and to read a report, so [reportCodec] holds it for both.
+//
+// NOTE: libocr decides whether to report based on the plain median of the
+// observations, and the on-chain contract takes the median of the transmitted
+// observations. Reports do not carry the aggregated value, so the factory
+// configuration only accepts the median until the contract applies the same
+// strategy; other aggregators are for codecs built in code.
+type Aggregator interface {
+	Aggregate(values []*big.Int, observers []commontypes.OracleID) (*big.Int, error)
+}
+
+// MedianAggregator returns the upper median, the value at index len/2.
+type MedianAggregator struct{}
+
+func (MedianAggregator) Aggregate(values []*big.Int, _ []commontypes.OracleID) (*big.Int, error) {
+	if len(values) == 0 {
+		return nil, errors.New("cannot aggregate empty observations")
+	}
+	return values[len(values)/2], nil
+}
+
+// WeightedMedianAggregator returns the first value at which the cumulative
+// weight exceeds half of the total weight. With equal weights this is the upper
+// median. Oracles missing from Weights have DefaultWeight, zero to ignore them.
+type WeightedMedianAggregator struct {
+	Weights       map[commontypes.OracleID]uint64
+	DefaultWeight uint64
+}
+
+func (a WeightedMedianAggregator) Aggregate(values []*big.Int, observers []commontypes.OracleID) (*big.Int, error) {
+	if len(values) == 0 {
+		return nil, errors.New("cannot aggregate empty observations")
+	}
+	if len(observers) != len(values) {
+		return nil, fmt.Errorf("got %d observers for %d observations", len(observers), len(values))
+	}
+	weights := make([]*big.Int, len(values))
+	total := new(big.Int)
+	for i, observer := range observers {
+		w, ok := a.Weights[observer]
+		if !ok {
+			w = a.DefaultWeight
+		}
+		weights[i] = new(big.Int).SetUint64(w)
+		total.Add(total, weights[i])
+	}
+	if total.Sign() == 0 {
+		return nil, errors.New("total weight of observers is zero")
+	}
+	cumulative := new(big.Int)
+	for i, w := range weights {
+		cumulative.Add(cumulative, w)
+		if new(big.Int).Lsh(cumulative, 1).Cmp(total) > 0 {
+			return values[i], nil
+		}
+	}
+	// Unreachable, the cumulative weight ends at total
+	return values[len(values)-1], nil
+}
+
+// TrimmedMeanAggregator drops TrimPercent of the observations, rounded down, at
+// each end and returns the mean of the rest, rounded toward zero.
+type TrimmedMeanAggregator struct {
+	TrimPercent uint8
+}
+
+func (a TrimmedMeanAggregator) Aggregate(values []*big.Int, _ []commontypes.OracleID) (*big.Int, error) {
+	k, err := trimCount(len(values), a.TrimPercent)
+	if err != nil {
+		return nil, err
+	}
+	return mean(values[k : len(values)-k]), nil
+}
+
+// WinsorizedMeanAggregator replaces WinsorizePercent of the observations, rounded
+// down, at each end with the nearest remaining value and returns the mean,
+// rounded toward zero.
+type WinsorizedMeanAggregator struct {
+	WinsorizePercent uint8
+}
+
+func (a WinsorizedMeanAggregator) Aggregate(values []*big.Int, _ []commontypes.OracleID) (*big.Int, error) {
+	k, err := trimCount(len(values), a.WinsorizePercent)
+	if err != nil {
+		return nil, err
+	}
+	winsorized := slices.Clone(values)
+	for i := 0; i < k; i++ {
+		winsorized[i] = values[k]
+		winsorized[len(values)-1-i] = values[len(values)-1-k]
+	}
+	return mean(winsorized), nil
+}
+
+// trimCount returns the number of observations to trim at each end, which always leaves at least one.
+func trimCount(n int, percent uint8) (int, error) {
+	if n == 0 {
+		return 0, errors.New("cannot aggregate empty observations")
+	}
+	if percent > MaxAggregatorPercent {
+		return 0, fmt.Errorf("percent %d exceeds %d", percent, MaxAggregatorPercent)
+	}
+	return n * int(percent) / 100, nil
+}
+
+func mean(values []*big.Int) *big.Int {
+	sum := new(big.Int)
+	for _, v := range values {
+		sum.Add(sum, v)
+	}
+	return sum.Quo(sum, big.NewInt(int64(len(values))))
+}
+
+// NewAggregator builds the aggregator described by opts, whose 'method' field is
+// one of median, weightedMedian, trimmedMean or winsorizedMean.
+func NewAggregator(opts map[string]any) (Aggregator, error) {
+	method, ok := opts["method"].(string)
+	if !ok {
+		return nil, errors.New("missing or invalid 'method' field in aggregator definition")
+	}
+	allowed := map[string]bool{"method": true}
+	var agg Aggregator
+	var err error
+	switch method {
+	case "median":
+		agg = MedianAggregator{}
+	case "weightedMedian":
+		allowed["weights"], allowed["defaultWeight"] = true, true
+		agg, err = newWeightedMedianAggregator(opts)
+	case "trimmedMean":
+		allowed["trimPercent"] = true
+		var percent uint8
+		percent, err = aggregatorPercentOption(opts, "trimPercent")
+		agg = TrimmedMeanAggregator{TrimPercent: percent}
+	case "winsorizedMean":
+		allowed["winsorizePercent"] = true
+		var percent uint8
+		percent, err = aggregatorPercentOption(opts, "winsorizePercent")
+		agg = WinsorizedMeanAggregator{WinsorizePercent: percent}
+	default:
+		return nil, fmt.Errorf("unsupported method in aggregator definition: %s", method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(opts)) {
+		if !allowed[key] {
+			return nil, fmt.Errorf("unknown field '%s' in aggregator definition", key)
+		}
+	}
+	return agg, nil
+}
+
+func newWeightedMedianAggregator(opts map[string]any) (Aggregator, error) {
+	agg := WeightedMedianAggregator{Weights: map[commontypes.OracleID]uint64{}, DefaultWeight: 1}
+	if _, ok := opts["defaultWeight"]; ok {
+		w, err := aggregatorIntegerOption(opts, "defaultWeight", maxSafeJSONInteger)
+		if err != nil {
+			return nil, err
+		}
+		agg.DefaultWeight = w
+	}
+	weights, ok := opts["weights"].(map[string]any)
+	if !ok {
+		return nil, errors.New("missing or invalid 'weights' field in aggregator definition")
+	}
+	for _, key := range slices.Sorted(maps.Keys(weights)) {
+		id, err := strconv.ParseUint(key, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'weights' field in aggregator definition: invalid oracle ID %q", key)
+		}
+		w, ok := weights[key].(float64)
+		if !ok || w < 0 || w > maxSafeJSONInteger || w != float64(uint64(w)) {
+			return nil, fmt.Errorf("invalid 'weights' field in aggregator definition: invalid weight %v of oracle %s", weights[key], key)
+		}
+		agg.Weights[commontypes.OracleID(id)] = uint64(w)
+	}
+	return agg, nil
+}
+
+func aggregatorPercentOption(opts map[string]any, key string) (uint8, error) {
+	percent, err := aggregatorIntegerOption(opts, key, MaxAggregatorPercent)
+	return uint8(percent), err
+}
+
+// aggregatorIntegerOption reads an integer field between 0 and hi.
+func aggregatorIntegerOption(opts map[string]any, key string, hi uint64) (uint64, error) {
+	v, err := integerOption(opts, key)
+	if err != nil || v > hi {
+		return 0, fmt.Errorf("missing or invalid '%s' field in aggregator definition, must be an integer between 0 and %d", key, hi)
+	}
+	return v, nil
+}
+
+// splitAggregatorConfig returns the aggregator selected by the factory
+// configuration cfg, nil if none is, and cfg without it. Only the median is
+// accepted, see [Aggregator].
+func splitAggregatorConfig(cfg map[string]any) (Aggregator, map[string]any, error) {
+	raw, ok := cfg[aggregatorKey]
+	if !ok {
+		return nil, cfg, nil
+	}
+	rest := maps.Clone(cfg)
+	delete(rest, aggregatorKey)
+	opts, ok := raw.(map[string]any)
+	if !ok {
+		return nil, nil, errors.New("missing or invalid 'aggregator' field in factory configuration")
+	}
+	agg, err := NewAggregator(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := agg.(MedianAggregator); !ok {
+		return nil, nil, fmt.Errorf("unsupported method in aggregator definition: %s, the on-chain contract takes the median of the observations", opts["method"])
+	}
+	return agg, rest, nil
+}
@@ -0,0 +1,186 @@
+package median
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bigInts(vs ...int64) []*big.Int {
+	out := make([]*big.Int, len(vs))
+	for i, v := range vs {
+		out[i] = big.NewInt(v)
+	}
+	return out
+}
+
+func Test_Aggregators(t *testing.T) {
+	observers := []commontypes.OracleID{4, 2, 0, 1, 3}
+	tcs := []struct {
+		name       string
+		aggregator Aggregator
+		values     []*big.Int
+		observers  []commontypes.OracleID
+
+		err      string
+		expected int64
+	}{
+		{name: "median odd", aggregator: MedianAggregator{}, values: bigInts(1, 2, 3, 4, 100), observers: observers, expected: 3},
+		{name: "median even is upper", aggregator: MedianAggregator{}, values: bigInts(1, 2, 3, 4), observers: observers[:4], expected: 3},
+		{name: "median empty", aggregator: MedianAggregator{}, err: "cannot aggregate empty observations"},
+		{
+			name:       "weighted median with equal weights is the median",
+			aggregator: WeightedMedianAggregator{DefaultWeight: 1},
+			values:     bigInts(1, 2, 3, 4),
+			observers:  observers[:4],
+			expected:   3,
+		},
+		{
+			name:       "weighted median follows the heavy oracle",
+			aggregator: WeightedMedianAggregator{Weights: map[commontypes.OracleID]uint64{1: 10}, DefaultWeight: 1},
+			values:     bigInts(1, 2, 3, 4, 100),
+			observers:  observers,
+			expected:   4,
+		},
+		{
+			name:       "weighted median ignores zero weights",
+			aggregator: WeightedMedianAggregator{Weights: map[commontypes.OracleID]uint64{0: 1, 1: 1, 3: 1}},
+			values:     bigInts(1, 2, 3, 4, 100),
+			observers:  observers,
+			expected:   4,
+		},
+		{
+			name:       "weighted median zero total weight",
+			aggregator: WeightedMedianAggregator{},
+			values:     bigInts(1, 2),
+			observers:  observers[:2],
+			err:        "total weight of observers is zero",
+		},
+		{
+			name:       "weighted median observers mismatch",
+			aggregator: WeightedMedianAggregator{DefaultWeight: 1},
+			values:     bigInts(1, 2),
+			observers:  observers[:1],
+			err:        "got 1 observers for 2 observations",
+		},
+		{name: "trimmed mean without trimming", aggregator: TrimmedMeanAggregator{}, values: bigInts(1, 2, 3, 4, 100), observers: observers, expected: 22},
+		{name: "trimmed mean drops the ends", aggregator: TrimmedMeanAggregator{TrimPercent: 20}, values: bigInts(1, 2, 3, 4, 100), observers: observers, expected: 3},
+		{name: "trimmed mean rounds the count down", aggregator: TrimmedMeanAggregator{TrimPercent: 19}, values: bigInts(1, 2, 3, 4, 100), observers: observers, expected: 22},
+		{name: "trimmed mean rounds toward zero", aggregator: TrimmedMeanAggregator{}, values: bigInts(-4, -3), observers: observers[:2], expected: -3},
+		{name: "trimmed mean too much", aggregator: TrimmedMeanAggregator{TrimPercent: 50}, values: bigInts(1), observers: observers[:1], err: "percent 50 exceeds 49"},
+		{name: "winsorized mean", aggregator: WinsorizedMeanAggregator{WinsorizePercent: 20}, values: bigInts(1, 2, 3, 4, 100), observers: observers, expected: 3},
+		{name: "winsorized mean keeps the count", aggregator: WinsorizedMeanAggregator{WinsorizePercent: 40}, values: bigInts(0, 10, 20, 30, 40, 50, 60, 70, 80, 1000), observers: make([]commontypes.OracleID, 10), expected: 45},
+		{name: "winsorized mean empty", aggregator: WinsorizedMeanAggregator{}, err: "cannot aggregate empty observations"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := tc.aggregator.Aggregate(tc.values, tc.observers)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, big.NewInt(tc.expected), actual)
+		})
+	}
+}
+
+func Test_NewAggregator(t *testing.T) {
+	tcs := []struct {
+		name     string
+		opts     map[string]any
+		err      string
+		expected Aggregator
+	}{
+		{name: "median", opts: map[string]any{"method": "median"}, expected: MedianAggregator{}},
+		{
+			name:     "weighted median",
+			opts:     map[string]any{"method": "weightedMedian", "weights": map[string]any{"0": float64(3), "7": float64(0)}},
+			expected: WeightedMedianAggregator{Weights: map[commontypes.OracleID]uint64{0: 3, 7: 0}, DefaultWeight: 1},
+		},
+		{
+			name:     "weighted median with default weight",
+			opts:     map[string]any{"method": "weightedMedian", "weights": map[string]any{}, "defaultWeight": float64(2)},
+			expected: WeightedMedianAggregator{Weights: map[commontypes.OracleID]uint64{}, DefaultWeight: 2},
+		},
+		{name: "trimmed mean", opts: map[string]any{"method": "trimmedMean", "trimPercent": float64(10)}, expected: TrimmedMeanAggregator{TrimPercent: 10}},
+		{name: "winsorized mean", opts: map[string]any{"method": "winsorizedMean", "winsorizePercent": float64(25)}, expected: WinsorizedMeanAggregator{WinsorizePercent: 25}},
+		{name: "missing method", opts: map[string]any{}, err: "missing or invalid 'method' field in aggregator definition"},
+		{name: "unknown method", opts: map[string]any{"method": "mode"}, err: "unsupported method in aggregator definition: mode"},
+		{name: "unknown field", opts: map[string]any{"method": "median", "trimPercent": float64(10)}, err: "unknown field 'trimPercent' in aggregator definition"},
+		{name: "missing weights", opts: map[string]any{"method": "weightedMedian"}, err: "missing or invalid 'weights' field in aggregator definition"},
+		{
+			name: "invalid oracle ID",
+			opts: map[string]any{"method": "weightedMedian", "weights": map[string]any{"256": float64(1)}},
+			err:  `invalid 'weights' field in aggregator definition: invalid oracle ID "256"`,
+		},
+		{
+			name: "fractional weight",
+			opts: map[string]any{"method": "weightedMedian", "weights": map[string]any{"1": 0.5}},
+			err:  "invalid 'weights' field in aggregator definition: invalid weight 0.5 of oracle 1",
+		},
+		{
+			name: "trim percent too large",
+			opts: map[string]any{"method": "trimmedMean", "trimPercent": float64(50)},
+			err:  "missing or invalid 'trimPercent' field in aggregator definition, must be an integer between 0 and 49",
+		},
+		{
+			name: "missing winsorize percent",
+			opts: map[string]any{"method": "winsorizedMean"},
+			err:  "missing or invalid 'winsorizePercent' field in aggregator definition, must be an integer between 0 and 49",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := NewAggregator(tc.opts)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func Test_splitAggregatorConfig(t *testing.T) {
+	t.Run("absent", func(t *testing.T) {
+		cfg := map[string]any{"type": "relative"}
+		agg, rest, err := splitAggregatorConfig(cfg)
+		require.NoError(t, err)
+		assert.Nil(t, agg)
+		assert.Equal(t, cfg, rest)
+	})
+	t.Run("aggregator only", func(t *testing.T) {
+		cfg := map[string]any{"aggregator": map[string]any{"method": "median"}}
+		agg, rest, err := splitAggregatorConfig(cfg)
+		require.NoError(t, err)
+		assert.Equal(t, MedianAggregator{}, agg)
+		assert.Empty(t, rest)
+		assert.Contains(t, cfg, "aggregator", "the configuration passed in is not modified")
+	})
+	t.Run("rejects aggregators the contract does not apply", func(t *testing.T) {
+		for _, opts := range []map[string]any{
+			{"method": "trimmedMean", "trimPercent": float64(20)},
+			{"method": "winsorizedMean", "winsorizePercent": float64(20)},
+			{"method": "weightedMedian", "weights": map[string]any{"0": float64(3)}},
+		} {
+			_, _, err := splitAggregatorConfig(map[string]any{"aggregator": opts})
+			require.EqualError(t, err, fmt.Sprintf("unsupported method in aggregator definition: %s, the on-chain contract takes the median of the observations", opts["method"]))
+		}
+	})
+	t.Run("with a deviation func", func(t *testing.T) {
+		agg, rest, err := splitAggregatorConfig(map[string]any{"type": "relative", "aggregator": map[string]any{"method": "median"}})
+		require.NoError(t, err)
+		assert.Equal(t, MedianAggregator{}, agg)
+		assert.Equal(t, map[string]any{"type": "relative"}, rest)
+	})
+	t.Run("not an object", func(t *testing.T) {
+		_, _, err := splitAggregatorConfig(map[string]any{"aggregator": "median"})
+		require.EqualError(t, err, "missing or invalid 'aggregator' field in factory configuration")
+	})
+}
@@ -0,0 +1,141 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// BacktestPoint is one value of a historical time series.
+type BacktestPoint struct {
+	Time  time.Time
+	Value *big.Int
+	// GasPriceSubunits and JuelsPerFeeCoin are optional. They are needed by
+	// gas-aware deviation funcs and to estimate the gas cost of updates.
+	GasPriceSubunits *big.Int
+	JuelsPerFeeCoin  *big.Int
+}
+
+// BacktestConfig describes the feed to replay a time series through.
+type BacktestConfig struct {
+	// Definition is a deviation function definition as passed to [NewDeviationFunc].
+	Definition   map[string]any
+	ThresholdPPB uint64
+	// Heartbeat forces an update once the on-chain value is this old, zero to disable.
+	Heartbeat time.Duration
+	// GasPerUpdate is the gas used by one transmission, zero to skip estimating gas cost.
+	GasPerUpdate uint64
+}
+
+// BacktestResult summarizes a replayed time series.
+type BacktestResult struct {
+	Points int
+	// Updates counts every transmission, including the initial one.
+	Updates          int
+	DeviationUpdates int
+	HeartbeatUpdates int
+	// MaxStaleness is the largest age of the on-chain value seen by any point.
+	MaxStaleness time.Duration
+	// MaxError is the largest absolute difference between the on-chain value and
+	// the true value, and MaxErrorPPB the largest relative one.
+	MaxError    *big.Int
+	MaxErrorPPB *big.Int
+	// EstimatedGasCost is the total cost of all updates in gas price subunits, nil
+	// if GasPerUpdate is zero or the series has no gas prices.
+	EstimatedGasCost *big.Int
+	// UnpricedUpdates counts the updates at points without a gas price, which
+	// EstimatedGasCost leaves out. Zero if GasPerUpdate is zero.
+	UnpricedUpdates int
+}
+
+// Backtest replays points, which must be in chronological order, through the
+// deviation func built from cfg.Definition the way the reporting plugin would:
+// the first point is always transmitted, later ones when the deviation func
+// fires or the heartbeat expires. The system and observationTimestamp clocks
+// read the time of each point, while the transmissionTimestamp clock reads the
+// time of the last simulated transmission, as it would on-chain.
+func Backtest(lggr logger.Logger, cfg BacktestConfig, points []BacktestPoint) (BacktestResult, error) {
+	result := BacktestResult{MaxError: new(big.Int), MaxErrorPPB: new(big.Int)}
+	if len(points) == 0 {
+		return result, errors.New("no points to backtest")
+	}
+	f, err := NewDeviationFunc(lggr, cfg.Definition)
+	if err != nil {
+		return result, err
+	}
+
+	clock := &backtestClock{}
+	var onchain *transmissionDetails
+	for i, p := range points {
+		if p.Value == nil {
+			return result, fmt.Errorf("point %d: missing value", i)
+		}
+		if i > 0 && p.Time.Before(points[i-1].Time) {
+			return result, fmt.Errorf("point %d: not in chronological order", i)
+		}
+		result.Points++
+		clock.now = p.Time
+
+		update := onchain == nil
+		if onchain != nil {
+			staleness := p.Time.Sub(onchain.timestamp)
+			result.MaxStaleness = max(result.MaxStaleness, staleness)
+			diff := new(big.Int).Sub(p.Value, onchain.latestAnswer)
+			diff.Abs(diff)
+			if diff.Cmp(result.MaxError) > 0 {
+				result.MaxError = diff
+			}
+			if onchain.latestAnswer.Sign() != 0 {
+				ppb := new(big.Int).Mul(diff, big.NewInt(1e9))
+				ppb.Quo(ppb, new(big.Int).Abs(onchain.latestAnswer))
+				if ppb.Cmp(result.MaxErrorPPB) > 0 {
+					result.MaxErrorPPB = ppb
+				}
+			}
+
+			round := &deviationRound{stage: deviationStageReport, clock: clock, observationTimestamp: p.Time, juelsPerFeeCoin: p.JuelsPerFeeCoin, gasPriceSubunits: p.GasPriceSubunits}
+			round.setLatestTransmission(*onchain)
+			deviates, err := f(withDeviationRound(context.Background(), round), cfg.ThresholdPPB, onchain.latestAnswer, p.Value)
+			if err != nil {
+				return result, fmt.Errorf("point %d: %w", i, err)
+			}
+			heartbeat := cfg.Heartbeat > 0 && staleness >= cfg.Heartbeat
+			update = deviates || heartbeat
+			if deviates {
+				result.DeviationUpdates++
+			} else if heartbeat {
+				result.HeartbeatUpdates++
+			}
+		}
+		if !update {
+			continue
+		}
+
+		result.Updates++
+		// Each transmission gets a new epoch, so funcs tracking transmissions see them.
+		onchain = &transmissionDetails{epoch: uint32(result.Updates), latestAnswer: p.Value, timestamp: p.Time}
+		if cfg.GasPerUpdate > 0 && p.GasPriceSubunits == nil {
+			result.UnpricedUpdates++
+		} else if cfg.GasPerUpdate > 0 {
+			if result.EstimatedGasCost == nil {
+				result.EstimatedGasCost = new(big.Int)
+			}
+			cost := new(big.Int).SetUint64(cfg.GasPerUpdate)
+			result.EstimatedGasCost.Add(result.EstimatedGasCost, cost.Mul(cost, p.GasPriceSubunits))
+		}
+	}
+	return result, nil
+}
+
+// backtestClock is the simulated clock of a backtest.
+type backtestClock struct {
+	now time.Time
+}
+
+func (c *backtestClock) Now() time.Time {
+	return c.now
+}
@@ -0,0 +1,92 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_Backtest(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	point := func(offset time.Duration, value string) BacktestPoint {
+		return BacktestPoint{Time: start.Add(offset), Value: valFromString(t, value), GasPriceSubunits: big.NewInt(1e10)}
+	}
+	relative := map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate"}
+
+	t.Run("counts updates, staleness and error", func(t *testing.T) {
+		result, err := Backtest(logger.Test(t), BacktestConfig{Definition: relative, ThresholdPPB: 1e7, Heartbeat: 24 * time.Hour, GasPerUpdate: 1e5}, []BacktestPoint{
+			point(0, "100"),
+			point(time.Hour, "100.5"),
+			point(2*time.Hour, "101"),
+			point(3*time.Hour, "101.5"),
+			point(27*time.Hour, "101.6"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 5, result.Points)
+		assert.Equal(t, 3, result.Updates)
+		assert.Equal(t, 1, result.DeviationUpdates)
+		assert.Equal(t, 1, result.HeartbeatUpdates)
+		assert.Equal(t, 25*time.Hour, result.MaxStaleness)
+		assert.Equal(t, valFromString(t, "1").String(), result.MaxError.String())
+		assert.Equal(t, "10000000", result.MaxErrorPPB.String())
+		assert.Equal(t, "3000000000000000", result.EstimatedGasCost.String())
+	})
+	t.Run("counts updates without a gas price", func(t *testing.T) {
+		unpriced := point(time.Hour, "110")
+		unpriced.GasPriceSubunits = nil
+		result, err := Backtest(logger.Test(t), BacktestConfig{Definition: relative, ThresholdPPB: 1e7, GasPerUpdate: 1e5}, []BacktestPoint{
+			point(0, "100"),
+			unpriced,
+			point(2*time.Hour, "120"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.Updates)
+		assert.Equal(t, 1, result.UnpricedUpdates)
+		assert.Equal(t, "2000000000000000", result.EstimatedGasCost.String())
+	})
+	t.Run("pendle uses the simulated clock", func(t *testing.T) {
+		// With the system clock, pendle reads the time of each point, so the same
+		// change deviates far from expiry and not close to it.
+		pendle := map[string]any{"type": "pendle", "expiresAt": float64(start.Add(365 * 24 * time.Hour).Unix())}
+		result, err := Backtest(logger.Test(t), BacktestConfig{Definition: pendle, ThresholdPPB: 1e7}, []BacktestPoint{
+			point(0, "0.8"),
+			point(time.Hour, "0.82"),
+			point(300*24*time.Hour, "0.8"),
+			point(364*24*time.Hour, "0.75"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.Updates)
+		assert.Equal(t, 1, result.DeviationUpdates)
+		assert.Equal(t, 364*24*time.Hour-time.Hour, result.MaxStaleness)
+		assert.Nil(t, result.EstimatedGasCost)
+	})
+	t.Run("hysteresis sees transmissions", func(t *testing.T) {
+		hysteresis := map[string]any{"type": "hysteresis", "consecutive": float64(2), "function": relative}
+		result, err := Backtest(logger.Test(t), BacktestConfig{Definition: hysteresis, ThresholdPPB: 1e7}, []BacktestPoint{
+			point(0, "100"),
+			point(time.Minute, "102"),
+			point(2*time.Minute, "102"),
+			point(3*time.Minute, "104"),
+			point(4*time.Minute, "106"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 2}, []int{result.Updates, result.DeviationUpdates})
+	})
+	t.Run("invalid definition", func(t *testing.T) {
+		_, err := Backtest(logger.Test(t), BacktestConfig{Definition: map[string]any{"type": "relative"}}, []BacktestPoint{point(0, "1")})
+		require.EqualError(t, err, "invalid deviation function definition: #: missing properties: 'zeroFloor'; #: missing properties: 'zeroPolicy'")
+	})
+	t.Run("no points", func(t *testing.T) {
+		_, err := Backtest(logger.Test(t), BacktestConfig{Definition: relative}, nil)
+		require.EqualError(t, err, "no points to backtest")
+	})
+	t.Run("out of order", func(t *testing.T) {
+		_, err := Backtest(logger.Test(t), BacktestConfig{Definition: relative}, []BacktestPoint{point(time.Hour, "1"), point(0, "1")})
+		require.EqualError(t, err, "point 1: not in chronological order")
+	})
+}
@@ -0,0 +1,96 @@
+package median
+
+import (
+	"math/big"
+)
+
+// deviationPrec is the mantissa precision, in bits, of every big.Float used by
+// deviation funcs. Together with deviationRounding it makes results identical on
+// every platform.
+const deviationPrec = 256
+
+const deviationRounding = big.ToNearestEven
+
+// newFloat returns a zero big.Float with the deviation precision and rounding mode.
+func newFloat() *big.Float {
+	return new(big.Float).SetPrec(deviationPrec).SetMode(deviationRounding)
+}
+
+func floatFromInt(x *big.Int) *big.Float {
+	return newFloat().SetInt(x)
+}
+
+func floatFromFloat64(x float64) *big.Float {
+	return newFloat().SetFloat64(x)
+}
+
+// floatFromRatio returns num/den.
+func floatFromRatio(num, den *big.Int) *big.Float {
+	return newFloat().Quo(floatFromInt(num), floatFromInt(den))
+}
+
+// ln2 is computed once at deviation precision.
+var ln2 = lnNearOne(floatFromInt(big.NewInt(2)))
+
+// bigLn returns the natural logarithm of x, which must be positive.
+func bigLn(x *big.Float) *big.Float {
+	// x = m * 2^exp with 0.5 <= m < 1, so ln(x) = ln(m) + exp*ln(2) and the series for ln(m) converges quickly.
+	m := newFloat()
+	exp := x.MantExp(m)
+	result := lnNearOne(m)
+	return result.Add(result, newFloat().Mul(ln2, newFloat().SetInt64(int64(exp))))
+}
+
+// lnNearOne computes ln(x) = 2*atanh((x-1)/(x+1)) by its power series. It
+// converges for any positive x, but only quickly for x close to 1.
+func lnNearOne(x *big.Float) *big.Float {
+	one := newFloat().SetInt64(1)
+	z := newFloat().Quo(newFloat().Sub(x, one), newFloat().Add(x, one))
+	z2 := newFloat().Mul(z, z)
+
+	sum := newFloat().Set(z)
+	power := newFloat().Set(z)
+	// Stop once terms no longer affect the result at this precision.
+	limit := newFloat().SetMantExp(one, -(deviationPrec + 8))
+	for k := int64(1); k < 4*deviationPrec; k++ {
+		power.Mul(power, z2)
+		term := newFloat().Quo(power, newFloat().SetInt64(2*k+1))
+		sum.Add(sum, term)
+		if term.Sign() == 0 || newFloat().Abs(term).Cmp(limit) < 0 {
+			break
+		}
+	}
+	return sum.Mul(sum, newFloat().SetInt64(2))
+}
+
+// maxExpArg bounds the argument of bigExp, so results stay far from the big.Float exponent limit.
+const maxExpArg = 1e6
+
+// bigExp returns e^x. It returns false if |x| exceeds maxExpArg.
+func bigExp(x *big.Float) (*big.Float, bool) {
+	if newFloat().Abs(x).Cmp(floatFromFloat64(maxExpArg)) > 0 {
+		return nil, false
+	}
+	// x = k*ln(2) + r with 0 <= r < ln(2), so e^x = 2^k * e^r and the series for e^r converges quickly.
+	kf := newFloat().Quo(x, ln2)
+	k, _ := kf.Int64()
+	if kf.Sign() < 0 && !kf.IsInt() {
+		k--
+	}
+	r := newFloat().Sub(x, newFloat().Mul(ln2, newFloat().SetInt64(k)))
+
+	one := newFloat().SetInt64(1)
+	sum := newFloat().Set(one)
+	term := newFloat().Set(one)
+	// Stop once terms no longer affect the result at this precision.
+	limit := newFloat().SetMantExp(one, -(deviationPrec + 8))
+	for n := int64(1); n < 4*deviationPrec; n++ {
+		term.Mul(term, r)
+		term.Quo(term, newFloat().SetInt64(n))
+		sum.Add(sum, term)
+		if term.Sign() == 0 || newFloat().Abs(term).Cmp(limit) < 0 {
+			break
+		}
+	}
+	return sum.SetMantExp(sum, int(k)), true
+}
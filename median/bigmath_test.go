@@ -0,0 +1,81 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_bigLn(t *testing.T) {
+	tcs := []struct {
+		x        string
+		expected string
+	}{
+		{x: "1", expected: "0"},
+		{x: "2", expected: "0.6931471805599453094172321214581765680755001343602552541206800094933936"},
+		{x: "1.01", expected: "0.009950330853168082848215357544260741688679609940058797864609559766866664"},
+		{x: "10", expected: "2.302585092994045684017991454684364207601101488628772976033327900967573"},
+		{x: "0.000000001", expected: "-20.72326583694641115616192309215927786840991339765895678429995110870815"},
+		{x: "18446744074.709551615", expected: "23.63815371894429875510952842630458388631938802392785461404729705751410"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.x, func(t *testing.T) {
+			x, ok := newFloat().SetString(tc.x)
+			require.True(t, ok)
+			expected, ok := newFloat().SetString(tc.expected)
+			require.True(t, ok)
+
+			actual := bigLn(x)
+			// Agree to well beyond float64 precision.
+			assert.Equal(t, expected.Text('g', 60), actual.Text('g', 60))
+		})
+	}
+}
+
+func Test_bigLn_Deterministic(t *testing.T) {
+	x := floatFromRatio(big.NewInt(1_010_000_000), big.NewInt(1e9))
+	assert.Equal(t, 0, bigLn(x).Cmp(bigLn(x)))
+	assert.Equal(t, uint(deviationPrec), bigLn(x).Prec())
+}
+
+func Test_bigExp(t *testing.T) {
+	tcs := []struct {
+		x        string
+		expected string
+	}{
+		{x: "0", expected: "1"},
+		{x: "1", expected: "2.718281828459045235360287471352662497757247093699959574966967627724077"},
+		{x: "-1", expected: "0.3678794411714423215955237701614608674458111310317678345078368016974614"},
+		{x: "0.05", expected: "1.051271096376024039697517636335645220174821296055062528783938479166280"},
+		{x: "10", expected: "22026.46579480671651695790064528424436635351261855678107423542635522520"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.x, func(t *testing.T) {
+			x, ok := newFloat().SetString(tc.x)
+			require.True(t, ok)
+			expected, ok := newFloat().SetString(tc.expected)
+			require.True(t, ok)
+
+			actual, ok := bigExp(x)
+			require.True(t, ok)
+			// Agree to well beyond float64 precision.
+			assert.Equal(t, expected.Text('g', 60), actual.Text('g', 60))
+		})
+	}
+
+	t.Run("inverse of bigLn", func(t *testing.T) {
+		x := floatFromRatio(big.NewInt(123_456_789), big.NewInt(1e6))
+		actual, ok := bigExp(bigLn(x))
+		require.True(t, ok)
+		assert.Equal(t, x.Text('g', 60), actual.Text('g', 60))
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		_, ok := bigExp(floatFromFloat64(maxExpArg + 1))
+		assert.False(t, ok)
+		_, ok = bigExp(floatFromFloat64(-maxExpArg - 1))
+		assert.False(t, ok)
+	})
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
 	"math/big"
+	"sync/atomic"
 	"time"
 
 	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
@@ -15,6 +15,10 @@ import (
 
 var DefaultMultiplier = new(big.Int).SetInt64(1e18)
 
+// NewDeviationFunc builds the deviation func described by opts. Its 'type' field
+// selects one of the types registered with [RegisterDeviationFunc]. Any
+// definition may also have a 'dustFilter' field, vetoing updates for changes
+// below a minimum absolute change or the on-chain precision of the feed.
 func NewDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
 	// Check for type field
 	typeVal, ok := opts["type"].(string)
@@ -22,30 +26,41 @@ func NewDeviationFunc(lggr logger.Logger, opts map[string]any) (median.Deviation
 		return nil, errors.New("missing or invalid 'type' field in deviation function definition")
 	}
 
-	switch typeVal {
-	case "pendle":
-		expiresAt, ok := opts["expiresAt"].(float64) // Assume its a unix TS, it can have fractions of a second
-		if !ok {
-			return nil, errors.New("missing or invalid 'expiresAt' field in deviation function definition")
-		}
-		var multiplier *big.Int
-		if multiplierStr, ok := opts["multiplier"].(string); ok { // Multiplier could be huge so we use string
-			multiplier = new(big.Int)
-			if _, ok := multiplier.SetString(multiplierStr, 10); !ok {
-				return nil, fmt.Errorf("invalid 'multiplier' field in deviation function definition: %s", multiplierStr)
-			}
-		} else {
-			multiplier = DefaultMultiplier
-		}
-
-		return makePendleDeviationFunc(lggr, expiresAt, SystemClock{}, multiplier), nil
-	default:
+	t, ok := lookupDeviationFunc(typeVal)
+	if !ok {
 		return nil, fmt.Errorf("unsupported function type in deviation function definition: %s", typeVal)
 	}
+	// The schema is checked first, so a misspelled key is reported as unknown
+	// rather than as a missing field. The constructor then checks what the schema
+	// cannot express, like bit lengths and relations between fields.
+	if err := validateDefinitionNode(t, opts, ""); err != nil {
+		return nil, err
+	}
+	return constructDeviationFunc(lggr, t, opts)
+}
+
+// constructDeviationFunc builds the deviation func of type t described by opts,
+// and its dust filter if any, without checking opts against the schema.
+func constructDeviationFunc(lggr logger.Logger, t *deviationFuncType, opts map[string]any) (median.DeviationFunc, error) {
+	dust, typeOpts, err := splitDustFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := t.ctor(lggr, typeOpts)
+	if err != nil {
+		return nil, err
+	}
+	if dust != nil {
+		f = makeDustFilterDeviationFunc(lggr, f, *dust)
+	}
+	return f, nil
 }
 
 const SecondsInYear = float64(365 * 24 * 60 * 60)
 
+// MaxExpiresAt is the largest accepted expiry, the last second of year 9999.
+const MaxExpiresAt = float64(253402300799)
+
 type Clock interface {
 	Now() time.Time
 }
@@ -56,37 +71,218 @@ func (SystemClock) Now() time.Time {
 	return time.Now()
 }
 
+// PostExpiryPolicy decides how the pendle deviation func behaves once expiresAt has passed.
+type PostExpiryPolicy string
+
+const (
+	// PostExpiryFreeze never reports a deviation after expiry.
+	PostExpiryFreeze PostExpiryPolicy = "freeze"
+	// PostExpiryFallbackRelative switches to the default relative deviation check after expiry.
+	PostExpiryFallbackRelative PostExpiryPolicy = "fallbackRelative"
+	// PostExpiryAlwaysUpdate reports a deviation on any change after expiry.
+	PostExpiryAlwaysUpdate PostExpiryPolicy = "alwaysUpdate"
+)
+
+type pendleConfig struct {
+	// expiresAt in seconds since epoch, possibly with fractions of a second
+	expiresAt  float64
+	timeSource TimeSource
+	multiplier *big.Int
+	postExpiry PostExpiryPolicy
+	// minHorizonSeconds is used in place of the time to expiry once fewer seconds remain
+	minHorizonSeconds float64
+}
+
+func newPendleDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	cfg, err := parsePendleConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return makePendleDeviationFunc(lggr, SystemClock{}, cfg), nil
+}
+
+// parsePendleConfig reads the expiry, scale and clock options shared by the pendle deviation types.
+func parsePendleConfig(opts map[string]any) (pendleConfig, error) {
+	cfg := pendleConfig{postExpiry: PostExpiryFreeze}
+	if _, ok := opts["expiresAt"].(float64); !ok { // Assume its a unix TS, it can have fractions of a second
+		return cfg, errors.New("missing or invalid 'expiresAt' field in deviation function definition")
+	}
+	var err error
+	if cfg.expiresAt, err = finiteFloatOption(opts, "expiresAt", 0, MaxExpiresAt); err != nil {
+		return cfg, err
+	}
+	// Multiplier could be huge so we use string, or a number of decimals
+	if cfg.multiplier, err = multiplierOption(opts, DefaultMultiplier); err != nil {
+		return cfg, err
+	}
+	if cfg.timeSource, err = parseTimeSource(opts); err != nil {
+		return cfg, err
+	}
+	if v, ok := opts["postExpiry"]; ok {
+		policy, _ := v.(string)
+		switch cfg.postExpiry = PostExpiryPolicy(policy); cfg.postExpiry {
+		case PostExpiryFreeze, PostExpiryFallbackRelative, PostExpiryAlwaysUpdate:
+		default:
+			return cfg, fmt.Errorf("invalid 'postExpiry' field in deviation function definition: %v", v)
+		}
+	}
+	if _, ok := opts["minHorizonSeconds"]; ok {
+		if cfg.minHorizonSeconds, err = finiteFloatOption(opts, "minHorizonSeconds", 0, MaxExpiresAt); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// expiryPhase is where a pendle feed is relative to its expiry.
+type expiryPhase int32
+
+const (
+	expiryPhaseActive expiryPhase = iota
+	// expiryPhaseGrace is the window before expiry in which the minimum horizon applies.
+	expiryPhaseGrace
+	expiryPhaseExpired
+)
+
+func (p expiryPhase) String() string {
+	switch p {
+	case expiryPhaseActive:
+		return "active"
+	case expiryPhaseGrace:
+		return "grace"
+	case expiryPhaseExpired:
+		return "expired"
+	default:
+		return fmt.Sprintf("expiryPhase(%d)", int32(p))
+	}
+}
+
+// expiryTracker logs each change of expiry phase once, however many rounds observe it.
+// Only the report stage updates it: the accept stage gets the time from another
+// source, so the phase seen by the two can differ and would flap back and forth.
+type expiryTracker struct {
+	lggr  logger.Logger
+	cfg   pendleConfig
+	phase atomic.Int32
+}
+
+// horizon returns the number of years to use as time to expiry at now, and the
+// current phase. The round in ctx, if any, tells the stage asking.
+func (e *expiryTracker) horizon(ctx context.Context, now time.Time) (*big.Float, expiryPhase) {
+	nowF := floatFromRatio(big.NewInt(now.UnixNano()), big.NewInt(1e9))
+	secondsToExpiry := newFloat().Sub(floatFromFloat64(e.cfg.expiresAt), nowF)
+	minHorizon := floatFromFloat64(e.cfg.minHorizonSeconds)
+
+	phase := expiryPhaseActive
+	switch {
+	case secondsToExpiry.Sign() <= 0:
+		phase = expiryPhaseExpired
+	case secondsToExpiry.Cmp(minHorizon) < 0:
+		phase = expiryPhaseGrace
+		secondsToExpiry = minHorizon
+	}
+
+	if round := deviationRoundFromContext(ctx); round == nil || round.stage == deviationStageReport {
+		if old := expiryPhase(e.phase.Swap(int32(phase))); old != phase {
+			e.lggr.Infow("Pendle expiry phase changed", "from", old.String(), "to", phase.String(), "expiresAt", e.cfg.expiresAt, "postExpiry", e.cfg.postExpiry, "minHorizonSeconds", e.cfg.minHorizonSeconds, "now", now)
+		}
+	}
+
+	// Convert expirationSeconds to years
+	return secondsToExpiry.Quo(secondsToExpiry, floatFromFloat64(SecondsInYear)), phase
+}
+
+// pendleDeviation holds the intermediate values of the pendle deviation check
+// |newVal - oldVal| / multiplier * yearsToExpiration > ln(1 + thresholdPPB/1e9).
+type pendleDeviation struct {
+	diff         *big.Float
+	product      *big.Float
+	logThreshold *big.Float
+}
+
+func (d pendleDeviation) deviates() bool {
+	return d.product.Cmp(d.logThreshold) > 0
+}
+
+// computePendleDeviation evaluates the pendle check entirely in big.Float at
+// deviationPrec, so the result does not depend on the platform's float64 math.
+func computePendleDeviation(thresholdPPB uint64, oldVal, newVal, multiplier *big.Int, yearsToExpiration *big.Float) pendleDeviation {
+	// Compute absolute difference |oldVal - newVal|, divided by multiplier
+	diff := new(big.Int).Sub(newVal, oldVal)
+	diff.Abs(diff)
+	diffF := floatFromRatio(diff, multiplier)
+
+	// Compute logarithmic threshold ln(1 + thresholdPPB/1e9) = ln((1e9 + thresholdPPB) / 1e9)
+	ppb := new(big.Int).SetUint64(thresholdPPB)
+	logThreshold := bigLn(floatFromRatio(ppb.Add(ppb, big.NewInt(1e9)), big.NewInt(1e9)))
+
+	return pendleDeviation{
+		diff:         diffF,
+		product:      newFloat().Mul(diffF, yearsToExpiration),
+		logThreshold: logThreshold,
+	}
+}
+
 // makePendleDeviationFunc makes a pendle-specific deviation func
 //
-// NOTE: This is non-deterministic if clock.Now() is non-deterministic (the usual case)
-// expiresAt expected as float64 number of seconds since epoch
-func makePendleDeviationFunc(lggr logger.Logger, expiresAt float64, clock Clock, valMultiplier *big.Int) median.DeviationFunc {
-	valMultiplierF := new(big.Float).SetInt(valMultiplier)
+// NOTE: With TimeSourceSystem this is non-deterministic if clock.Now() is non-deterministic (the usual case).
+// The other time sources take "now" from the round being evaluated, so all oracles reach the same decision.
+func makePendleDeviationFunc(lggr logger.Logger, clock Clock, cfg pendleConfig) median.DeviationFunc {
+	expiry := &expiryTracker{lggr: lggr, cfg: cfg}
 	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
 		if oldVal == nil || newVal == nil {
 			return false, errors.New("oldVal and newVal must be non-nil")
 		}
 
-		nowF64 := float64(clock.Now().UnixNano()) / 1e9
-		// Convert expirationSeconds to years
-		yearsToExpiration := (expiresAt - nowF64) / SecondsInYear
-
-		// Compute absolute difference |oldVal - newVal|
-		diff := new(big.Int).Sub(newVal, oldVal)
-		diff.Abs(diff) // Take absolute value
-		// Convert big.Int to float64 for calculation
-		diffFloat := new(big.Float).SetInt(diff)
-		// Divide by multiplier
-		diffFloat = diffFloat.Quo(diffFloat, valMultiplierF)
-		diffF64, _ := diffFloat.Float64()
+		now, err := deviationNow(ctx, clock, cfg.timeSource)
+		if err != nil {
+			return false, err
+		}
+		yearsToExpiration, phase := expiry.horizon(ctx, now)
 
-		// Compute logarithmic threshold
-		logThreshold := math.Log(1 + float64(thresholdPPB)/1e9)
+		if phase == expiryPhaseExpired {
+			return expiredPendleDeviates(ctx, lggr, "pendle", cfg, now, thresholdPPB, oldVal, newVal)
+		}
 
-		deviates := (diffF64 * yearsToExpiration) > logThreshold
+		d := computePendleDeviation(thresholdPPB, oldVal, newVal, cfg.multiplier, yearsToExpiration)
+		deviates := d.deviates()
 
-		lggr.Debugw("PendleDeviationFunc", "valMultiplier", valMultiplier.String(), "expiresAt", expiresAt, "thresholdPPB", thresholdPPB, "oldVal", oldVal.String(), "newVal", newVal.String(), "nowF64", nowF64, "yearsToExpiration", yearsToExpiration, "diffF64", diffF64, "logThreshold", logThreshold, "diffF64*yearsToExpiration", diffF64*yearsToExpiration, "deviates", deviates)
+		decision := newDeviationDecision("pendle", thresholdPPB, oldVal, newVal)
+		decision.Time, decision.Deviates = now, deviates
+		decision.Intermediates["phase"] = phase.String()
+		decision.Intermediates["timeSource"] = cfg.timeSource
+		decision.Intermediates["valMultiplier"] = cfg.multiplier.String()
+		decision.Intermediates["expiresAt"] = cfg.expiresAt
+		decision.Intermediates["yearsToExpiration"] = yearsToExpiration.Text('g', 30)
+		decision.Intermediates["diff"] = d.diff.Text('g', 30)
+		decision.Intermediates["logThreshold"] = d.logThreshold.Text('g', 30)
+		decision.Intermediates["diff*yearsToExpiration"] = d.product.Text('g', 30)
+		recordDecision(ctx, lggr, decision)
 		// Return the comparison result
 		return deviates, nil
 	}
 }
+
+// expiredPendleDeviates applies the post expiry policy of cfg and records the decision as typ.
+func expiredPendleDeviates(ctx context.Context, lggr logger.Logger, typ string, cfg pendleConfig, now time.Time, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+	var deviates bool
+	switch cfg.postExpiry {
+	case PostExpiryAlwaysUpdate:
+		deviates = oldVal.Cmp(newVal) != 0
+	case PostExpiryFallbackRelative:
+		var err error
+		deviates, err = median.DefaultDeviationFunc(ctx, thresholdPPB, oldVal, newVal)
+		if err != nil {
+			return false, err
+		}
+	case PostExpiryFreeze:
+		// The feed only updates on heartbeat once expired
+	}
+	decision := newDeviationDecision(typ, thresholdPPB, oldVal, newVal)
+	decision.Time, decision.Deviates = now, deviates
+	decision.Intermediates["phase"] = expiryPhaseExpired.String()
+	decision.Intermediates["postExpiry"] = cfg.postExpiry
+	decision.Intermediates["expiresAt"] = cfg.expiresAt
+	recordDecision(ctx, lggr, decision)
+	return deviates, nil
+}
@@ -0,0 +1,56 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func newAbsoluteDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	threshold, err := positiveBigIntOption(opts, "threshold")
+	if err != nil {
+		return nil, err
+	}
+	var combineRelative bool
+	if v, ok := opts["combineRelative"]; ok {
+		if combineRelative, ok = v.(bool); !ok {
+			return nil, errors.New("invalid 'combineRelative' field in deviation function definition")
+		}
+	}
+	return makeAbsoluteDeviationFunc(lggr, threshold, combineRelative), nil
+}
+
+// makeAbsoluteDeviationFunc makes a deviation func that fires when |newVal - oldVal|
+// reaches threshold, in the same units as the feed values. thresholdPPB is ignored
+// unless combineRelative is set, in which case the func also fires on a relative
+// deviation of at least thresholdPPB.
+func makeAbsoluteDeviationFunc(lggr logger.Logger, threshold *big.Int, combineRelative bool) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		diff := new(big.Int).Sub(newVal, oldVal)
+		diff.Abs(diff)
+		absoluteDeviates := diff.Cmp(threshold) >= 0
+
+		// A zero old value has no relative deviation, so only the absolute check applies.
+		relativeDeviatesVal := combineRelative && oldVal.Sign() != 0 && relativeDeviates(thresholdPPB, oldVal, newVal)
+
+		deviates := absoluteDeviates || relativeDeviatesVal
+
+		decision := newDeviationDecision("absolute", thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["threshold"] = threshold.String()
+		decision.Intermediates["combineRelative"] = combineRelative
+		decision.Intermediates["diff"] = diff.String()
+		decision.Intermediates["absoluteDeviates"] = absoluteDeviates
+		decision.Intermediates["relativeDeviates"] = relativeDeviatesVal
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
@@ -0,0 +1,151 @@
+package median
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_Absolute(t *testing.T) {
+	t.Run("missing threshold", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute"})
+		require.EqualError(t, err, "missing or invalid 'threshold' field in deviation function definition")
+	})
+	t.Run("threshold as number", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": float64(10)})
+		require.EqualError(t, err, "missing or invalid 'threshold' field in deviation function definition")
+	})
+	t.Run("non-numeric threshold", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1e18"})
+		require.EqualError(t, err, "invalid 'threshold' field in deviation function definition: 1e18")
+	})
+	t.Run("zero threshold", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "0"})
+		require.EqualError(t, err, "invalid 'threshold' field in deviation function definition: must be positive, got 0")
+	})
+	t.Run("negative threshold", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "-5"})
+		require.EqualError(t, err, "invalid 'threshold' field in deviation function definition: must be positive, got -5")
+	})
+	t.Run("oversized threshold", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1" + strings.Repeat("0", 100)})
+		require.EqualError(t, err, "invalid 'threshold' field in deviation function definition: exceeds 256 bits")
+	})
+	t.Run("invalid combineRelative", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "5", "combineRelative": "yes"})
+		require.EqualError(t, err, "invalid 'combineRelative' field in deviation function definition")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1000000000000000000000"})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1, big.NewInt(0), valFromString(t, "1000"))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+		deviates, err = f(nil, 1, big.NewInt(0), valFromString(t, "999.999"))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+}
+
+func Test_AbsoluteDeviationFunc(t *testing.T) {
+	tcs := []struct {
+		name string
+
+		threshold       *big.Int
+		combineRelative bool
+		thresholdPPB    uint64
+		oldVal          *big.Int
+		newVal          *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:      "nil oldVal errors",
+			threshold: big.NewInt(1),
+			oldVal:    nil,
+			newVal:    big.NewInt(2),
+			err:       "oldVal and newVal must be non-nil",
+		},
+		{
+			name:      "nil newVal errors",
+			threshold: big.NewInt(1),
+			oldVal:    big.NewInt(1),
+			newVal:    nil,
+			err:       "oldVal and newVal must be non-nil",
+		},
+		{
+			name:      "increase at threshold - SHOULD UPDATE",
+			threshold: big.NewInt(25),
+			oldVal:    big.NewInt(500),
+			newVal:    big.NewInt(525),
+			expected:  true,
+		},
+		{
+			name:      "decrease at threshold - SHOULD UPDATE",
+			threshold: big.NewInt(25),
+			oldVal:    big.NewInt(500),
+			newVal:    big.NewInt(475),
+			expected:  true,
+		},
+		{
+			name:      "below threshold - SHOULD NOT UPDATE",
+			threshold: big.NewInt(25),
+			oldVal:    big.NewInt(500),
+			newVal:    big.NewInt(524),
+			expected:  false,
+		},
+		{
+			name:         "thresholdPPB ignored without combineRelative - SHOULD NOT UPDATE",
+			threshold:    big.NewInt(25),
+			thresholdPPB: 1,
+			oldVal:       big.NewInt(500),
+			newVal:       big.NewInt(524),
+			expected:     false,
+		},
+		{
+			name:            "relative fires when combined - SHOULD UPDATE",
+			threshold:       big.NewInt(25),
+			combineRelative: true,
+			thresholdPPB:    4e7,
+			oldVal:          big.NewInt(500),
+			newVal:          big.NewInt(520),
+			expected:        true,
+		},
+		{
+			name:            "neither fires when combined - SHOULD NOT UPDATE",
+			threshold:       big.NewInt(25),
+			combineRelative: true,
+			thresholdPPB:    5e7,
+			oldVal:          big.NewInt(500),
+			newVal:          big.NewInt(520),
+			expected:        false,
+		},
+		{
+			name:            "zero old value only checks absolute - SHOULD NOT UPDATE",
+			threshold:       big.NewInt(25),
+			combineRelative: true,
+			thresholdPPB:    1,
+			oldVal:          big.NewInt(0),
+			newVal:          big.NewInt(24),
+			expected:        false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := makeAbsoluteDeviationFunc(logger.Test(t), tc.threshold, tc.combineRelative)(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func newAsymmetricDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	up, err := optionalThresholdPPB(opts, "upThresholdPPB")
+	if err != nil {
+		return nil, err
+	}
+	down, err := optionalThresholdPPB(opts, "downThresholdPPB")
+	if err != nil {
+		return nil, err
+	}
+	if up == nil && down == nil {
+		return nil, errors.New("at least one of 'upThresholdPPB' and 'downThresholdPPB' must be set in deviation function definition")
+	}
+	return makeAsymmetricDeviationFunc(lggr, up, down), nil
+}
+
+// optionalThresholdPPB returns nil if key is not set.
+func optionalThresholdPPB(opts map[string]any, key string) (*uint64, error) {
+	if _, ok := opts[key]; !ok {
+		return nil, nil
+	}
+	v, err := integerOption(opts, key)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// makeAsymmetricDeviationFunc makes a relative deviation func with separate
+// thresholds for increases (up) and decreases (down) of the value. A nil
+// threshold falls back to the thresholdPPB the func is called with.
+func makeAsymmetricDeviationFunc(lggr logger.Logger, upThresholdPPB, downThresholdPPB *uint64) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		direction := "up"
+		effectivePPB := thresholdPPB
+		if newVal.Cmp(oldVal) < 0 {
+			direction = "down"
+			if downThresholdPPB != nil {
+				effectivePPB = *downThresholdPPB
+			}
+		} else if upThresholdPPB != nil {
+			effectivePPB = *upThresholdPPB
+		}
+
+		var deviates bool
+		if oldVal.Sign() == 0 {
+			deviates = newVal.Sign() != 0
+		} else {
+			deviates = relativeDeviates(effectivePPB, oldVal, newVal)
+		}
+
+		decision := newDeviationDecision("asymmetric", thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["direction"] = direction
+		decision.Intermediates["effectiveThresholdPPB"] = effectivePPB
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
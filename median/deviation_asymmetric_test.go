@@ -0,0 +1,170 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func Test_NewDeviationFunc_Asymmetric(t *testing.T) {
+	t.Run("no thresholds", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "asymmetric"})
+		require.EqualError(t, err, "at least one of 'upThresholdPPB' and 'downThresholdPPB' must be set in deviation function definition")
+	})
+	t.Run("invalid threshold", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "asymmetric", "downThresholdPPB": float64(-1)})
+		require.EqualError(t, err, "missing or invalid 'downThresholdPPB' field in deviation function definition")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "asymmetric", "upThresholdPPB": float64(2e7), "downThresholdPPB": float64(5e6)})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1000), big.NewInt(995))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+		deviates, err = f(nil, 1e7, big.NewInt(1000), big.NewInt(1015))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+}
+
+func Test_AsymmetricDeviationFunc(t *testing.T) {
+	tcs := []struct {
+		name string
+
+		upThresholdPPB   *uint64
+		downThresholdPPB *uint64
+		thresholdPPB     uint64
+		oldVal           *big.Int
+		newVal           *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:           "nil oldVal errors",
+			upThresholdPPB: ptr[uint64](1),
+			oldVal:         nil,
+			newVal:         big.NewInt(2),
+			err:            "oldVal and newVal must be non-nil",
+		},
+		{
+			name:           "nil newVal errors",
+			upThresholdPPB: ptr[uint64](1),
+			oldVal:         big.NewInt(1),
+			newVal:         nil,
+			err:            "oldVal and newVal must be non-nil",
+		},
+		{
+			name:             "drop above down threshold - SHOULD UPDATE",
+			upThresholdPPB:   ptr[uint64](2e7),
+			downThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:     1e7,
+			oldVal:           valFromString(t, "2000"),
+			newVal:           valFromString(t, "1990"),
+			expected:         true,
+		},
+		{
+			name:             "drop below down threshold - SHOULD NOT UPDATE",
+			upThresholdPPB:   ptr[uint64](2e7),
+			downThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:     1e7,
+			oldVal:           valFromString(t, "2000"),
+			newVal:           valFromString(t, "1990.01"),
+			expected:         false,
+		},
+		{
+			name:             "rise of the same size below up threshold - SHOULD NOT UPDATE",
+			upThresholdPPB:   ptr[uint64](2e7),
+			downThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:     1e7,
+			oldVal:           valFromString(t, "2000"),
+			newVal:           valFromString(t, "2010"),
+			expected:         false,
+		},
+		{
+			name:             "rise above up threshold - SHOULD UPDATE",
+			upThresholdPPB:   ptr[uint64](2e7),
+			downThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:     1e7,
+			oldVal:           valFromString(t, "2000"),
+			newVal:           valFromString(t, "2040"),
+			expected:         true,
+		},
+		{
+			name:             "unset up threshold falls back to configured threshold - SHOULD UPDATE",
+			downThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:     1e7,
+			oldVal:           valFromString(t, "2000"),
+			newVal:           valFromString(t, "2020"),
+			expected:         true,
+		},
+		{
+			name:           "unset down threshold falls back to configured threshold - SHOULD NOT UPDATE",
+			upThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:   1e7,
+			oldVal:         valFromString(t, "2000"),
+			newVal:         valFromString(t, "1990"),
+			expected:       false,
+		},
+		{
+			name:             "negative values use numeric direction - SHOULD UPDATE",
+			upThresholdPPB:   ptr[uint64](2e7),
+			downThresholdPPB: ptr[uint64](5e6),
+			thresholdPPB:     1e7,
+			oldVal:           valFromString(t, "-2000"),
+			newVal:           valFromString(t, "-2010"),
+			expected:         true,
+		},
+		{
+			name:             "zero old value - SHOULD UPDATE",
+			downThresholdPPB: ptr[uint64](5e6),
+			oldVal:           big.NewInt(0),
+			newVal:           big.NewInt(-1),
+			expected:         true,
+		},
+		{
+			name:             "unchanged - SHOULD NOT UPDATE",
+			upThresholdPPB:   ptr[uint64](1),
+			downThresholdPPB: ptr[uint64](1),
+			oldVal:           big.NewInt(5),
+			newVal:           big.NewInt(5),
+			expected:         false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var oldValS, newValS string
+			if tc.oldVal != nil {
+				oldValS = tc.oldVal.String()
+			}
+			if tc.newVal != nil {
+				newValS = tc.newVal.String()
+			}
+
+			actual, err := makeAsymmetricDeviationFunc(logger.Test(t), tc.upThresholdPPB, tc.downThresholdPPB)(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				if actual != tc.expected {
+					t.Fatalf("expected %v, got %v", tc.expected, actual)
+				}
+			}
+
+			// Did not mutate passed args
+			if tc.oldVal != nil {
+				assert.Equal(t, oldValS, tc.oldVal.String())
+			}
+			if tc.newVal != nil {
+				assert.Equal(t, newValS, tc.newVal.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// MaxCompositeDepth bounds how deeply definitions wrapping other definitions may nest.
+const MaxCompositeDepth = 8
+
+func newCompositeDeviationFunc(lggr logger.Logger, opts map[string]any, requireAll bool) (median.DeviationFunc, error) {
+	children, ok := opts["functions"].([]any)
+	if !ok || len(children) == 0 {
+		return nil, errors.New("missing or invalid 'functions' field in deviation function definition")
+	}
+	if compositeDepth(opts) > MaxCompositeDepth {
+		return nil, fmt.Errorf("invalid 'functions' field in deviation function definition: nesting exceeds %d levels", MaxCompositeDepth)
+	}
+
+	funcs := make([]median.DeviationFunc, len(children))
+	for i, child := range children {
+		childOpts, ok := child.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid 'functions[%d]' field in deviation function definition: expected an object", i)
+		}
+		f, err := NewDeviationFunc(lggr, childOpts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'functions[%d]' field in deviation function definition: %w", i, err)
+		}
+		funcs[i] = f
+	}
+
+	return makeCompositeDeviationFunc(lggr, funcs, requireAll), nil
+}
+
+// compositeDepth returns the number of levels of nested definitions in opts.
+func compositeDepth(opts map[string]any) int {
+	maxChild := 0
+	for _, child := range childDefinitions(opts) {
+		maxChild = max(maxChild, compositeDepth(child.opts))
+	}
+	return maxChild + 1
+}
+
+// makeCompositeDeviationFunc makes a deviation func combining funcs with OR, or
+// with AND if requireAll is set.
+//
+// Every child is evaluated on every call, even once the result is known, so that
+// children keeping state across rounds see the same inputs on every oracle.
+func makeCompositeDeviationFunc(lggr logger.Logger, funcs []median.DeviationFunc, requireAll bool) median.DeviationFunc {
+	typ := "any"
+	if requireAll {
+		typ = "all"
+	}
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		var fired []int
+		for i, f := range funcs {
+			deviates, err := f(ctx, thresholdPPB, oldVal, newVal)
+			if err != nil {
+				return false, fmt.Errorf("functions[%d]: %w", i, err)
+			}
+			if deviates {
+				fired = append(fired, i)
+			}
+		}
+
+		deviates := len(fired) > 0
+		if requireAll {
+			deviates = len(fired) == len(funcs)
+		}
+
+		decision := newDeviationDecision(typ, thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["fired"] = fired
+		decision.Intermediates["numFunctions"] = len(funcs)
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
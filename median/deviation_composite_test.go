@@ -0,0 +1,136 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func constDeviationFunc(result bool, err error) median.DeviationFunc {
+	return func(context.Context, uint64, *big.Int, *big.Int) (bool, error) {
+		return result, err
+	}
+}
+
+func Test_NewDeviationFunc_Composite(t *testing.T) {
+	absolute := map[string]any{"type": "absolute", "threshold": "100"}
+	relative := map[string]any{"type": "relative", "zeroPolicy": "neverUpdate"}
+
+	t.Run("missing functions", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "any"})
+		require.EqualError(t, err, "missing or invalid 'functions' field in deviation function definition")
+	})
+	t.Run("empty functions", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "all", "functions": []any{}})
+		require.EqualError(t, err, "missing or invalid 'functions' field in deviation function definition")
+	})
+	t.Run("child is not an object", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "any", "functions": []any{absolute, "pendle"}})
+		require.EqualError(t, err, "invalid 'functions[1]' field in deviation function definition: expected an object")
+	})
+	t.Run("invalid nested child", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "any", "functions": []any{
+			absolute,
+			map[string]any{"type": "all", "functions": []any{relative, map[string]any{"type": "absolute"}}},
+		}})
+		require.EqualError(t, err, "invalid 'functions[1]' field in deviation function definition: invalid 'functions[1]' field in deviation function definition: invalid deviation function definition: #: missing properties: 'threshold'")
+	})
+	t.Run("nesting too deep", func(t *testing.T) {
+		def := absolute
+		for range MaxCompositeDepth + 1 {
+			def = map[string]any{"type": "any", "functions": []any{def}}
+		}
+		_, err := newDeviationFunc(t, def)
+		require.EqualError(t, err, "invalid 'functions' field in deviation function definition: nesting exceeds 8 levels")
+	})
+	t.Run("any - valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "any", "functions": []any{absolute, relative}})
+		require.NoError(t, err)
+		deviates, err := f(nil, 5e8, big.NewInt(1000), big.NewInt(1100))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+		deviates, err = f(nil, 5e8, big.NewInt(1000), big.NewInt(1099))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+	t.Run("all - valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "all", "functions": []any{absolute, relative}})
+		require.NoError(t, err)
+		deviates, err := f(nil, 5e8, big.NewInt(1000), big.NewInt(1100))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+		deviates, err = f(nil, 5e8, big.NewInt(1000), big.NewInt(1500))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+}
+
+func Test_CompositeDeviationFunc(t *testing.T) {
+	tcs := []struct {
+		name string
+
+		funcs      []median.DeviationFunc
+		requireAll bool
+
+		err      string
+		expected bool
+		fired    []int
+	}{
+		{
+			name:     "any, none fire",
+			funcs:    []median.DeviationFunc{constDeviationFunc(false, nil), constDeviationFunc(false, nil)},
+			expected: false,
+		},
+		{
+			name:     "any, second fires",
+			funcs:    []median.DeviationFunc{constDeviationFunc(false, nil), constDeviationFunc(true, nil)},
+			expected: true,
+			fired:    []int{1},
+		},
+		{
+			name:       "all, one fires",
+			funcs:      []median.DeviationFunc{constDeviationFunc(true, nil), constDeviationFunc(false, nil)},
+			requireAll: true,
+			expected:   false,
+			fired:      []int{0},
+		},
+		{
+			name:       "all, both fire",
+			funcs:      []median.DeviationFunc{constDeviationFunc(true, nil), constDeviationFunc(true, nil)},
+			requireAll: true,
+			expected:   true,
+			fired:      []int{0, 1},
+		},
+		{
+			name:  "child error is wrapped with its index",
+			funcs: []median.DeviationFunc{constDeviationFunc(true, nil), constDeviationFunc(false, errors.New("boom"))},
+			err:   "functions[1]: boom",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			lggr, logs := logger.TestObserved(t, zapcore.DebugLevel)
+			actual, err := makeCompositeDeviationFunc(lggr, tc.funcs, tc.requireAll)(nil, 1e7, big.NewInt(1), big.NewInt(2))
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+
+			entries := logs.All()
+			require.Len(t, entries, 1)
+			assert.Equal(t, fmt.Sprint(tc.fired), fmt.Sprint(entries[0].ContextMap()["fired"]))
+		})
+	}
+}
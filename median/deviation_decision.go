@@ -0,0 +1,165 @@
+package median
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// DefaultDecisionHistory is the number of decisions kept per feed by the plugin.
+const DefaultDecisionHistory = 100
+
+// DeviationDecision records why a deviation func did or did not report a
+// deviation, with the inputs and intermediate values it based its result on.
+type DeviationDecision struct {
+	// Feed is the contract ID of the feed, set by the plugin.
+	Feed string `json:"feed,omitempty"`
+	// Type is the deviation function type that made the decision.
+	Type string `json:"type"`
+	// Time is the time the decision was made at, as seen by the deviation func
+	// for time dependent types and by the system clock otherwise.
+	Time time.Time `json:"time"`
+	// ConfigDigest and Stage identify the round, if evaluated by the reporting plugin.
+	ConfigDigest string `json:"configDigest,omitempty"`
+	Stage        string `json:"stage,omitempty"`
+
+	ThresholdPPB uint64 `json:"thresholdPPB"`
+	OldVal       string `json:"oldVal"`
+	NewVal       string `json:"newVal"`
+	// Intermediates holds the type specific values the result is derived from.
+	Intermediates map[string]any `json:"intermediates,omitempty"`
+	Deviates      bool           `json:"deviates"`
+}
+
+func newDeviationDecision(typ string, thresholdPPB uint64, oldVal, newVal *big.Int) DeviationDecision {
+	return DeviationDecision{Type: typ, ThresholdPPB: thresholdPPB, OldVal: oldVal.String(), NewVal: newVal.String(), Intermediates: map[string]any{}}
+}
+
+// DecisionSink receives the decisions made by deviation funcs. It must be safe for concurrent use.
+type DecisionSink interface {
+	RecordDecision(DeviationDecision)
+}
+
+// recordDecision sends d to the sink of the round in ctx, or logs it if there is none.
+func recordDecision(ctx context.Context, lggr logger.Logger, d DeviationDecision) {
+	round := deviationRoundFromContext(ctx)
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+		if round != nil && round.clock != nil {
+			d.Time = round.clock.Now()
+		}
+	}
+	if round != nil {
+		d.ConfigDigest = round.configDigest.Hex()
+		d.Stage = round.stage.String()
+		if round.decisions != nil {
+			round.decisions.RecordDecision(d)
+			return
+		}
+	}
+	LoggerDecisionSink{Logger: lggr}.RecordDecision(d)
+}
+
+// LoggerDecisionSink logs each decision at debug level.
+type LoggerDecisionSink struct {
+	Logger logger.Logger
+}
+
+func (s LoggerDecisionSink) RecordDecision(d DeviationDecision) {
+	kvs := []any{"type", d.Type, "time", d.Time, "thresholdPPB", d.ThresholdPPB, "oldVal", d.OldVal, "newVal", d.NewVal, "deviates", d.Deviates}
+	if d.Feed != "" {
+		kvs = append(kvs, "feed", d.Feed)
+	}
+	if d.ConfigDigest != "" {
+		kvs = append(kvs, "configDigest", d.ConfigDigest, "stage", d.Stage)
+	}
+	for _, k := range slices.Sorted(maps.Keys(d.Intermediates)) {
+		kvs = append(kvs, k, d.Intermediates[k])
+	}
+	s.Logger.Debugw("DeviationDecision", kvs...)
+}
+
+// DecisionRing keeps the most recent decisions in memory.
+type DecisionRing struct {
+	mu        sync.Mutex
+	decisions []DeviationDecision
+	next      int
+	full      bool
+}
+
+// NewDecisionRing returns a DecisionRing keeping the last size decisions.
+func NewDecisionRing(size int) *DecisionRing {
+	return &DecisionRing{decisions: make([]DeviationDecision, max(size, 1))}
+}
+
+func (r *DecisionRing) RecordDecision(d DeviationDecision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decisions[r.next] = d
+	r.next = (r.next + 1) % len(r.decisions)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Decisions returns the kept decisions, oldest first.
+func (r *DecisionRing) Decisions() []DeviationDecision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return slices.Clone(r.decisions[:r.next])
+	}
+	return append(slices.Clone(r.decisions[r.next:]), r.decisions[:r.next]...)
+}
+
+// JSONLDecisionSink writes each decision as a line of JSON.
+type JSONLDecisionSink struct {
+	lggr logger.Logger
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewJSONLDecisionSink returns a sink writing to w. Write errors are logged with lggr.
+func NewJSONLDecisionSink(lggr logger.Logger, w io.Writer) *JSONLDecisionSink {
+	return &JSONLDecisionSink{lggr: lggr, w: w}
+}
+
+func (s *JSONLDecisionSink) RecordDecision(d DeviationDecision) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		s.lggr.Errorw("Failed to encode deviation decision", "err", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		s.lggr.Errorw("Failed to write deviation decision", "err", err)
+	}
+}
+
+// DecisionSinks sends every decision to each of its sinks.
+type DecisionSinks []DecisionSink
+
+func (s DecisionSinks) RecordDecision(d DeviationDecision) {
+	for _, sink := range s {
+		sink.RecordDecision(d)
+	}
+}
+
+// feedDecisionSink tags decisions with the feed they were made for.
+type feedDecisionSink struct {
+	feed string
+	sink DecisionSink
+}
+
+func (s feedDecisionSink) RecordDecision(d DeviationDecision) {
+	d.Feed = s.feed
+	s.sink.RecordDecision(d)
+}
@@ -0,0 +1,196 @@
+package median
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_DecisionRing(t *testing.T) {
+	ring := NewDecisionRing(3)
+	assert.Empty(t, ring.Decisions())
+
+	for i := range 5 {
+		ring.RecordDecision(DeviationDecision{ThresholdPPB: uint64(i)})
+		decisions := ring.Decisions()
+		require.Len(t, decisions, min(i+1, 3))
+		// Oldest first, so the latest decision is always last.
+		assert.Equal(t, uint64(i), decisions[len(decisions)-1].ThresholdPPB)
+	}
+	decisions := ring.Decisions()
+	assert.Equal(t, []uint64{2, 3, 4}, []uint64{decisions[0].ThresholdPPB, decisions[1].ThresholdPPB, decisions[2].ThresholdPPB})
+}
+
+func Test_JSONLDecisionSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONLDecisionSink(logger.Test(t), &buf)
+	d := newDeviationDecision("relative", 1e7, big.NewInt(1000), big.NewInt(1010))
+	d.Time = time.Unix(100, 0).UTC()
+	d.Deviates = true
+	d.Intermediates["oldRounded"] = "1000"
+	sink.RecordDecision(d)
+	sink.RecordDecision(d)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"type":"relative","time":"1970-01-01T00:01:40Z","thresholdPPB":10000000,"oldVal":"1000","newVal":"1010","intermediates":{"oldRounded":"1000"},"deviates":true}`, string(lines[0]))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func Test_JSONLDecisionSink_WriteError(t *testing.T) {
+	lggr, logs := logger.TestObserved(t, zapcore.ErrorLevel)
+	NewJSONLDecisionSink(lggr, failingWriter{}).RecordDecision(DeviationDecision{Type: "relative"})
+	assert.Equal(t, 1, logs.FilterMessage("Failed to write deviation decision").Len())
+}
+
+func Test_RecordDecision(t *testing.T) {
+	t.Run("logs without round data", func(t *testing.T) {
+		lggr, logs := logger.TestObserved(t, zapcore.DebugLevel)
+		f := makeRelativeDeviationFunc(lggr, ZeroPolicyNeverUpdate, nil, nil)
+		_, err := f(nil, 1e7, big.NewInt(1000), big.NewInt(1010))
+		require.NoError(t, err)
+
+		entries := logs.FilterMessage("DeviationDecision").All()
+		require.Len(t, entries, 1)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "relative", fields["type"])
+		assert.Equal(t, true, fields["deviates"])
+		assert.Equal(t, "1000", fields["oldRounded"])
+	})
+	t.Run("sends to the round's sink", func(t *testing.T) {
+		lggr, logs := logger.TestObserved(t, zapcore.DebugLevel)
+		ring := NewDecisionRing(10)
+		digest := ocrtypes.ConfigDigest{1}
+		ctx := withDeviationRound(context.Background(), &deviationRound{configDigest: digest, stage: deviationStageAccept, decisions: feedDecisionSink{feed: "0xfeed", sink: ring}})
+
+		f := makeCompositeDeviationFunc(lggr, []median.DeviationFunc{makeAbsoluteDeviationFunc(lggr, big.NewInt(5), false)}, false)
+		deviates, err := f(ctx, 1e7, big.NewInt(1000), big.NewInt(1003))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+
+		assert.Zero(t, logs.Len())
+		decisions := ring.Decisions()
+		require.Len(t, decisions, 2)
+		// Nested funcs record their own decision before the one wrapping them.
+		assert.Equal(t, "absolute", decisions[0].Type)
+		assert.Equal(t, "any", decisions[1].Type)
+		for _, d := range decisions {
+			assert.Equal(t, "0xfeed", d.Feed)
+			assert.Equal(t, digest.Hex(), d.ConfigDigest)
+			assert.Equal(t, "accept", d.Stage)
+			assert.Equal(t, "1003", d.NewVal)
+			assert.False(t, d.Time.IsZero())
+		}
+		assert.Equal(t, "3", decisions[0].Intermediates["diff"])
+	})
+	t.Run("uses the deviation func's time", func(t *testing.T) {
+		ring := NewDecisionRing(10)
+		ctx := withDeviationRound(context.Background(), &deviationRound{decisions: ring})
+		now := time.Unix(1700000000, 0)
+		cal, err := parseTradingCalendar(map[string]any{"timezone": "UTC", "sessions": []any{map[string]any{"days": []any{"mon"}, "open": "00:00", "close": "24:00"}}})
+		require.NoError(t, err)
+
+		_, err = makeMarketHoursDeviationFunc(logger.Test(t), &mutableClock{now: now}, TimeSourceSystem, cal, nil)(ctx, 1e7, big.NewInt(1), big.NewInt(2))
+		require.NoError(t, err)
+		decisions := ring.Decisions()
+		require.Len(t, decisions, 1)
+		assert.Equal(t, now, decisions[0].Time)
+	})
+}
+
+func Test_Plugin_FeedState(t *testing.T) {
+	p := NewPlugin(logger.Test(t))
+
+	first := p.openFeed("feed")
+	first.decisions.RecordDecision(DeviationDecision{ThresholdPPB: 1})
+	first.driftRejections.add([]commontypes.OracleID{2})
+
+	// A replacement factory opens before the old one is closed and keeps the history.
+	second := p.openFeed("feed")
+	assert.Same(t, first, second)
+	p.closeFeed("feed")
+	assert.Len(t, p.DeviationDecisions("feed"), 1)
+	assert.Equal(t, map[commontypes.OracleID]uint64{2: 1}, p.TimestampDriftRejections("feed"))
+
+	// Closing the last factory drops the state of the feed.
+	s := &reportingPluginFactoryService{lggr: logger.Test(t), onClose: func() { p.closeFeed("feed") }}
+	_ = s.Close()
+	_ = s.Close()
+	assert.Nil(t, p.DeviationDecisions("feed"))
+	assert.Nil(t, p.TimestampDriftRejections("feed"))
+	assert.Empty(t, p.feeds)
+
+	p.closeFeed("unknown")
+	assert.Empty(t, p.feeds)
+}
+
+func Test_Plugin_DecisionHistory(t *testing.T) {
+	p := NewPlugin(logger.Test(t), WithDecisionHistory(2))
+	feed := p.openFeed("feed")
+	for i := range 3 {
+		feed.decisions.RecordDecision(DeviationDecision{ThresholdPPB: uint64(i)})
+	}
+	decisions := p.DeviationDecisions("feed")
+	require.Len(t, decisions, 2)
+	assert.Equal(t, uint64(1), decisions[0].ThresholdPPB)
+
+	assert.Len(t, NewPlugin(logger.Test(t)).openFeed("feed").decisions.decisions, DefaultDecisionHistory)
+}
+
+func Test_Plugin_DecisionsHandler(t *testing.T) {
+	p := NewPlugin(logger.Test(t))
+	p.openFeed("feed").decisions.RecordDecision(DeviationDecision{Type: "relative", ThresholdPPB: 1, OldVal: "1", NewVal: "2", Deviates: true})
+	p.openFeed("other")
+	h := p.DecisionsHandler()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	t.Run("one feed", func(t *testing.T) {
+		w := get("/?feed=feed")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var decisions []DeviationDecision
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decisions))
+		require.Len(t, decisions, 1)
+		assert.Equal(t, "relative", decisions[0].Type)
+		assert.True(t, decisions[0].Deviates)
+	})
+	t.Run("every feed", func(t *testing.T) {
+		w := get("/")
+		require.Equal(t, http.StatusOK, w.Code)
+		var all map[string][]DeviationDecision
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &all))
+		assert.Len(t, all["feed"], 1)
+		assert.Contains(t, all, "other")
+	})
+	t.Run("unknown feed", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/?feed=unknown").Code)
+	})
+	t.Run("only GET", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
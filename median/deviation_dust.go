@@ -0,0 +1,116 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// dustFilterKey is the field of a deviation function definition, of any type,
+// that configures its dust filter. It is not part of the schema of any type.
+const dustFilterKey = "dustFilter"
+
+var dustFilterSchema = mustCompileBuiltinSchema(dustFilterKey)
+
+type dustFilter struct {
+	// minAbsoluteChange is the smallest change that may trigger an update, nil if unused
+	minAbsoluteChange *big.Int
+	// precisionUnit is one unit at the on-chain decimals in feed values, nil if unused
+	precisionUnit *big.Int
+}
+
+// splitDustFilter returns the dust filter of opts, nil if it has none, and opts without it.
+func splitDustFilter(opts map[string]any) (*dustFilter, map[string]any, error) {
+	raw, ok := opts[dustFilterKey]
+	if !ok {
+		return nil, opts, nil
+	}
+	rest := maps.Clone(opts)
+	delete(rest, dustFilterKey)
+
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, nil, errors.New("missing or invalid 'dustFilter' field in deviation function definition")
+	}
+	var filter dustFilter
+	if _, ok := m["minAbsoluteChange"]; ok {
+		v, err := positiveBigIntOption(m, "minAbsoluteChange")
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid 'dustFilter' field in deviation function definition: %w", err)
+		}
+		filter.minAbsoluteChange = v
+	}
+	_, hasFeedDecimals := m["feedDecimals"]
+	_, hasValueDecimals := m["valueDecimals"]
+	if hasValueDecimals && !hasFeedDecimals {
+		return nil, nil, errors.New("invalid 'dustFilter' field in deviation function definition: 'valueDecimals' requires 'feedDecimals'")
+	}
+	if hasFeedDecimals {
+		feedDecimals, err := decimalsOption(m, "feedDecimals")
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid 'dustFilter' field in deviation function definition: %w", err)
+		}
+		// Values have the decimals of the definition unless the filter says otherwise.
+		valueDecimals := uint64(DefaultDecimals)
+		if _, ok := rest["decimals"]; ok {
+			if valueDecimals, err = decimalsOption(rest, "decimals"); err != nil {
+				return nil, nil, err
+			}
+		}
+		if hasValueDecimals {
+			if valueDecimals, err = decimalsOption(m, "valueDecimals"); err != nil {
+				return nil, nil, fmt.Errorf("invalid 'dustFilter' field in deviation function definition: %w", err)
+			}
+		}
+		if feedDecimals > valueDecimals {
+			return nil, nil, fmt.Errorf("invalid 'dustFilter' field in deviation function definition: 'feedDecimals' %d exceeds 'valueDecimals' %d", feedDecimals, valueDecimals)
+		}
+		filter.precisionUnit = new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(valueDecimals-feedDecimals), nil)
+	}
+	if filter.minAbsoluteChange == nil && filter.precisionUnit == nil {
+		return nil, nil, errors.New("invalid 'dustFilter' field in deviation function definition: at least one of 'minAbsoluteChange' and 'feedDecimals' must be set")
+	}
+	return &filter, rest, nil
+}
+
+// makeDustFilterDeviationFunc wraps inner so that it never reports a deviation
+// for a change smaller than minAbsoluteChange or than one unit at the on-chain
+// precision. Inner is evaluated on every call, so stateful funcs see every round.
+func makeDustFilterDeviationFunc(lggr logger.Logger, inner median.DeviationFunc, filter dustFilter) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		deviates, err := inner(ctx, thresholdPPB, oldVal, newVal)
+		if err != nil || !deviates {
+			return deviates, err
+		}
+
+		change := new(big.Int).Sub(newVal, oldVal)
+		change.Abs(change)
+		var reason string
+		switch {
+		case filter.minAbsoluteChange != nil && change.Cmp(filter.minAbsoluteChange) < 0:
+			reason = "belowMinAbsoluteChange"
+		case filter.precisionUnit != nil && change.Cmp(filter.precisionUnit) < 0:
+			reason = "belowFeedPrecision"
+		default:
+			return true, nil
+		}
+
+		decision := newDeviationDecision(dustFilterKey, thresholdPPB, oldVal, newVal)
+		decision.Intermediates["vetoReason"] = reason
+		decision.Intermediates["change"] = change.String()
+		if filter.minAbsoluteChange != nil {
+			decision.Intermediates["minAbsoluteChange"] = filter.minAbsoluteChange.String()
+		}
+		if filter.precisionUnit != nil {
+			decision.Intermediates["precisionUnit"] = filter.precisionUnit.String()
+		}
+		recordDecision(ctx, lggr, decision)
+		return false, nil
+	}
+}
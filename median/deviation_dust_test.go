@@ -0,0 +1,200 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_DustFilterDeviationFunc(t *testing.T) {
+	tcs := []struct {
+		name string
+
+		innerDeviates bool
+		innerErr      error
+		filter        dustFilter
+		oldVal        *big.Int
+		newVal        *big.Int
+
+		err        string
+		expected   bool
+		vetoReason string
+	}{
+		{
+			name:     "inner error is returned",
+			innerErr: errors.New("boom"),
+			filter:   dustFilter{minAbsoluteChange: big.NewInt(10)},
+			oldVal:   big.NewInt(100),
+			newVal:   big.NewInt(101),
+			err:      "boom",
+		},
+		{
+			name:     "inner not deviating passes through",
+			filter:   dustFilter{minAbsoluteChange: big.NewInt(10)},
+			oldVal:   big.NewInt(100),
+			newVal:   big.NewInt(200),
+			expected: false,
+		},
+		{
+			name:          "below minAbsoluteChange is vetoed",
+			innerDeviates: true,
+			filter:        dustFilter{minAbsoluteChange: big.NewInt(10)},
+			oldVal:        big.NewInt(100),
+			newVal:        big.NewInt(91),
+			expected:      false,
+			vetoReason:    "belowMinAbsoluteChange",
+		},
+		{
+			name:          "at minAbsoluteChange passes",
+			innerDeviates: true,
+			filter:        dustFilter{minAbsoluteChange: big.NewInt(10)},
+			oldVal:        big.NewInt(100),
+			newVal:        big.NewInt(90),
+			expected:      true,
+		},
+		{
+			name:          "below feed precision is vetoed",
+			innerDeviates: true,
+			filter:        dustFilter{precisionUnit: big.NewInt(1e10)},
+			oldVal:        valFromString(t, "1.00000000"),
+			newVal:        valFromString(t, "1.000000009"),
+			expected:      false,
+			vetoReason:    "belowFeedPrecision",
+		},
+		{
+			name:          "one unit of feed precision passes",
+			innerDeviates: true,
+			filter:        dustFilter{precisionUnit: big.NewInt(1e10)},
+			oldVal:        valFromString(t, "1.00000000"),
+			newVal:        valFromString(t, "1.00000001"),
+			expected:      true,
+		},
+		{
+			name:          "both limits, the larger one applies",
+			innerDeviates: true,
+			filter:        dustFilter{minAbsoluteChange: big.NewInt(5e10), precisionUnit: big.NewInt(1e10)},
+			oldVal:        valFromString(t, "1.00000000"),
+			newVal:        valFromString(t, "1.00000004"),
+			expected:      false,
+			vetoReason:    "belowMinAbsoluteChange",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ring := NewDecisionRing(10)
+			ctx := withDeviationRound(context.Background(), &deviationRound{decisions: ring})
+			f := makeDustFilterDeviationFunc(logger.Test(t), constDeviationFunc(tc.innerDeviates, tc.innerErr), tc.filter)
+			actual, err := f(ctx, 1e7, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+
+			decisions := ring.Decisions()
+			if tc.vetoReason == "" {
+				assert.Empty(t, decisions)
+				return
+			}
+			require.Len(t, decisions, 1)
+			assert.Equal(t, "dustFilter", decisions[0].Type)
+			assert.False(t, decisions[0].Deviates)
+			assert.Equal(t, tc.vetoReason, decisions[0].Intermediates["vetoReason"])
+		})
+	}
+}
+
+func Test_NewDeviationFunc_DustFilter(t *testing.T) {
+	t.Run("on a built-in type", func(t *testing.T) {
+		ring := NewDecisionRing(10)
+		ctx := withDeviationRound(context.Background(), &deviationRound{decisions: ring})
+		f, err := newDeviationFunc(t, map[string]any{
+			"type":       "relative",
+			"zeroPolicy": "alwaysUpdate",
+			"dustFilter": map[string]any{"feedDecimals": float64(8)},
+		})
+		require.NoError(t, err)
+
+		// From zero any change is a deviation, but the change is below 1e-8
+		deviates, err := f(ctx, 1e7, big.NewInt(0), big.NewInt(1e9))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+		deviates, err = f(ctx, 1e7, big.NewInt(0), big.NewInt(1e10))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+
+		decisions := ring.Decisions()
+		require.Len(t, decisions, 3)
+		assert.Equal(t, "relative", decisions[0].Type)
+		assert.Equal(t, "dustFilter", decisions[1].Type)
+		assert.Equal(t, "belowFeedPrecision", decisions[1].Intermediates["vetoReason"])
+		assert.Equal(t, "relative", decisions[2].Type)
+	})
+	t.Run("values have the decimals of the definition", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{
+			"type":       "relative",
+			"zeroPolicy": "alwaysUpdate",
+			"decimals":   float64(10),
+			"dustFilter": map[string]any{"feedDecimals": float64(8)},
+		})
+		require.NoError(t, err)
+
+		// One unit at 8 decimals is 100 at 10
+		deviates, err := f(nil, 1e7, big.NewInt(0), big.NewInt(99))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+		deviates, err = f(nil, 1e7, big.NewInt(0), big.NewInt(100))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+
+		_, err = newDeviationFunc(t, map[string]any{
+			"type":       "relative",
+			"zeroPolicy": "alwaysUpdate",
+			"decimals":   float64(6),
+			"dustFilter": map[string]any{"feedDecimals": float64(8)},
+		})
+		require.EqualError(t, err, "invalid 'dustFilter' field in deviation function definition: 'feedDecimals' 8 exceeds 'valueDecimals' 6")
+	})
+	t.Run("on a nested definition", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "any", "functions": []any{
+			map[string]any{"type": "absolute", "threshold": "1", "dustFilter": map[string]any{"minAbsoluteChange": "100"}},
+		}})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(0), big.NewInt(99))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+	t.Run("not an object", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1", "dustFilter": "100"})
+		require.EqualError(t, err, "missing or invalid 'dustFilter' field in deviation function definition")
+	})
+	t.Run("empty", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1", "dustFilter": map[string]any{}})
+		require.EqualError(t, err, "invalid 'dustFilter' field in deviation function definition: at least one of 'minAbsoluteChange' and 'feedDecimals' must be set")
+	})
+	t.Run("invalid minAbsoluteChange", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1", "dustFilter": map[string]any{"minAbsoluteChange": "0"}})
+		require.EqualError(t, err, "invalid 'dustFilter' field in deviation function definition: invalid 'minAbsoluteChange' field in deviation function definition: must be positive, got 0")
+	})
+	t.Run("feedDecimals exceeds valueDecimals", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1", "dustFilter": map[string]any{"feedDecimals": float64(8), "valueDecimals": float64(6)}})
+		require.EqualError(t, err, "invalid 'dustFilter' field in deviation function definition: 'feedDecimals' 8 exceeds 'valueDecimals' 6")
+	})
+	t.Run("valueDecimals without feedDecimals", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "absolute", "threshold": "1", "dustFilter": map[string]any{"minAbsoluteChange": "1", "valueDecimals": float64(6)}})
+		require.EqualError(t, err, "invalid 'dustFilter' field in deviation function definition: 'valueDecimals' requires 'feedDecimals'")
+	})
+	t.Run("unknown field is reported with its path", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{"type": "any", "functions": []any{
+			map[string]any{"type": "absolute", "threshold": "1", "dustFilter": map[string]any{"feedDecimals": float64(8), "minChange": "1"}},
+		}})
+		require.EqualError(t, err, "invalid deviation function definition: #/functions/0/dustFilter: additionalProperties 'minChange' not allowed")
+	})
+}
@@ -0,0 +1,113 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"regexp"
+	"slices"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// MaxExpressionConstants bounds the number of user constants of an expression deviation func.
+const MaxExpressionConstants = 64
+
+var (
+	constantNamePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+	expressionVariableList = []string{"oldVal", "newVal", "thresholdPPB", "now"}
+)
+
+func newExpressionDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	src, ok := opts["expression"].(string)
+	if !ok {
+		return nil, errors.New("missing or invalid 'expression' field in deviation function definition")
+	}
+
+	variables := map[string]bool{}
+	for _, name := range expressionVariableList {
+		variables[name] = true
+	}
+	constants := exprEnv{}
+	if v, ok := opts["constants"]; ok {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, errors.New("missing or invalid 'constants' field in deviation function definition")
+		}
+		if len(m) > MaxExpressionConstants {
+			return nil, fmt.Errorf("invalid 'constants' field in deviation function definition: more than %d constants", MaxExpressionConstants)
+		}
+		for _, name := range slices.Sorted(maps.Keys(m)) {
+			raw := m[name]
+			_, isFunction := exprFunctions[name]
+			if !constantNamePattern.MatchString(name) || variables[name] || isFunction || name == "true" || name == "false" {
+				return nil, fmt.Errorf("invalid 'constants' field in deviation function definition: invalid name %q", name)
+			}
+			s, _ := raw.(string)
+			v, ok := ParseDecimal(s, MaxDecimalStringLength)
+			if !ok {
+				return nil, fmt.Errorf("invalid 'constants.%s' field in deviation function definition: %v", name, raw)
+			}
+			constants[name] = v
+		}
+	}
+	for name := range constants {
+		variables[name] = true
+	}
+
+	expr, err := compileExpression(src, variables, exprBool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'expression' field in deviation function definition: %w", err)
+	}
+	timeSource, err := parseTimeSource(opts)
+	if err != nil {
+		return nil, err
+	}
+	return makeExpressionDeviationFunc(lggr, SystemClock{}, timeSource, src, expr, constants), nil
+}
+
+// makeExpressionDeviationFunc makes a deviation func evaluating a compiled boolean
+// expression over oldVal, newVal, thresholdPPB, now (unix seconds) and constants.
+// All values are exact rationals, so the result is the same on every oracle for
+// the same inputs. The clock is only read if the expression uses now.
+func makeExpressionDeviationFunc(lggr logger.Logger, clock Clock, timeSource TimeSource, src string, expr *expression, constants exprEnv) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		env := exprEnv{
+			"oldVal":       new(big.Rat).SetInt(oldVal),
+			"newVal":       new(big.Rat).SetInt(newVal),
+			"thresholdPPB": new(big.Rat).SetInt(new(big.Int).SetUint64(thresholdPPB)),
+		}
+		if expr.variables["now"] {
+			now, err := deviationNow(ctx, clock, timeSource)
+			if err != nil {
+				return false, err
+			}
+			env["now"] = new(big.Rat).SetFrac(big.NewInt(now.UnixNano()), big.NewInt(1e9))
+		}
+		for name, v := range constants {
+			env[name] = v
+		}
+
+		result, err := expr.eval(env)
+		if err != nil {
+			return false, fmt.Errorf("evaluating deviation expression: %w", err)
+		}
+
+		decision := newDeviationDecision("expression", thresholdPPB, oldVal, newVal)
+		decision.Deviates = result.boolean
+		decision.Intermediates["expression"] = src
+		if now, ok := env["now"]; ok {
+			decision.Intermediates["now"] = now.FloatString(3)
+		}
+		recordDecision(ctx, lggr, decision)
+		return result.boolean, nil
+	}
+}
@@ -0,0 +1,148 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_Expression(t *testing.T) {
+	t.Run("missing expression", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "expression"})
+		require.EqualError(t, err, "missing or invalid 'expression' field in deviation function definition")
+	})
+	t.Run("expression does not compile", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "expression", "expression": "newVal - oldVal"})
+		require.EqualError(t, err, "invalid 'expression' field in deviation function definition: expression must evaluate to a boolean, not a number")
+	})
+	t.Run("undefined constant", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "expression", "expression": "newVal > limit"})
+		require.EqualError(t, err, `invalid 'expression' field in deviation function definition: unknown identifier "limit" at offset 9`)
+	})
+	t.Run("constant shadows variable", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "expression", "expression": "newVal > 1", "constants": map[string]any{"oldVal": "1"}})
+		require.EqualError(t, err, `invalid 'constants' field in deviation function definition: invalid name "oldVal"`)
+	})
+	t.Run("constant in exponent notation", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "expression", "expression": "newVal > limit", "constants": map[string]any{"limit": "1e1000000000"}})
+		require.EqualError(t, err, "invalid 'constants.limit' field in deviation function definition: 1e1000000000")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{
+			"type":       "expression",
+			"expression": "abs(newVal - oldVal) >= limit || abs(newVal - oldVal) * 1000000000 >= abs(oldVal) * thresholdPPB",
+			"constants":  map[string]any{"limit": "-5"},
+		})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1000), big.NewInt(1001))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+}
+
+func Test_ExpressionDeviationFunc(t *testing.T) {
+	// The pendle check written as an expression
+	pendle := "abs(newVal - oldVal) / multiplier * (expiresAt - now) / 31536000 > ln(1 + thresholdPPB / 1000000000)"
+	constants := map[string]any{"multiplier": "1000000000000000000", "expiresAt": "1623243541"}
+
+	tcs := []struct {
+		name string
+
+		expression   string
+		constants    map[string]any
+		timeSource   TimeSource
+		thresholdPPB uint64
+		oldVal       *big.Int
+		newVal       *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:       "nil oldVal errors",
+			expression: "newVal > oldVal",
+			oldVal:     nil,
+			newVal:     big.NewInt(2),
+			err:        "oldVal and newVal must be non-nil",
+		},
+		{
+			name:         "pendle, large deviation - SHOULD UPDATE",
+			expression:   pendle,
+			constants:    constants,
+			thresholdPPB: 1e7,
+			oldVal:       valFromString(t, "0.187152977881070687"),
+			newVal:       valFromString(t, "0.16"),
+			expected:     true,
+		},
+		{
+			name:         "pendle, small deviation - SHOULD NOT UPDATE",
+			expression:   pendle,
+			constants:    constants,
+			thresholdPPB: 1e7,
+			oldVal:       valFromString(t, "0.187152977881070687"),
+			newVal:       valFromString(t, "0.177777777777777777"),
+			expected:     false,
+		},
+		{
+			name:       "now without round data errors",
+			expression: "now > 0",
+			timeSource: TimeSourceObservationTimestamp,
+			oldVal:     big.NewInt(1),
+			newVal:     big.NewInt(2),
+			err:        `clock "observationTimestamp" requires round data, which is only available inside the reporting plugin`,
+		},
+		{
+			name:       "clock is not read unless now is used - SHOULD UPDATE",
+			expression: "newVal != oldVal",
+			timeSource: TimeSourceObservationTimestamp,
+			oldVal:     big.NewInt(1),
+			newVal:     big.NewInt(2),
+			expected:   true,
+		},
+		{
+			name:       "evaluation errors are returned",
+			expression: "newVal / oldVal > 1",
+			oldVal:     big.NewInt(0),
+			newVal:     big.NewInt(2),
+			err:        "evaluating deviation expression: division by zero",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			timeSource := tc.timeSource
+			if timeSource == "" {
+				timeSource = TimeSourceSystem
+			}
+			opts := map[string]any{"type": "expression", "expression": tc.expression, "clock": string(timeSource)}
+			if tc.constants != nil {
+				opts["constants"] = tc.constants
+			}
+			_, err := newDeviationFunc(t, opts)
+			require.NoError(t, err)
+
+			constants := exprEnv{}
+			variables := map[string]bool{"oldVal": true, "newVal": true, "thresholdPPB": true, "now": true}
+			for name, v := range tc.constants {
+				constants[name], _ = new(big.Rat).SetString(v.(string))
+				variables[name] = true
+			}
+			expr, err := compileExpression(tc.expression, variables, exprBool)
+			require.NoError(t, err)
+
+			clock := &mutableClock{now: time.Unix(1609386000, 0)}
+			actual, err := makeExpressionDeviationFunc(logger.Test(t), clock, timeSource, tc.expression, expr, constants)(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+type gasCostConfig struct {
+	// gasPerTransmission is the gas used by one transmission
+	gasPerTransmission *big.Int
+	// feeCoinUnit is the number of gas price subunits in one fee coin
+	feeCoinUnit *big.Int
+	// valueAtRiskJuels is the value, in juels, lost to a stale price per unit of relative deviation
+	valueAtRiskJuels *big.Int
+	floorPPB         uint64
+	capPPB           uint64
+}
+
+func newGasAwareDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	var cfg gasCostConfig
+	gas, err := integerOption(opts, "gasPerTransmission")
+	if err != nil {
+		return nil, err
+	}
+	if gas == 0 {
+		return nil, errors.New("invalid 'gasPerTransmission' field in deviation function definition: must be positive")
+	}
+	cfg.gasPerTransmission = new(big.Int).SetUint64(gas)
+	feeCoinDecimals := uint64(DefaultDecimals)
+	if _, ok := opts["feeCoinDecimals"]; ok {
+		if feeCoinDecimals, err = decimalsOption(opts, "feeCoinDecimals"); err != nil {
+			return nil, err
+		}
+	}
+	cfg.feeCoinUnit = new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(feeCoinDecimals), nil)
+	if cfg.valueAtRiskJuels, err = positiveBigIntOption(opts, "valueAtRiskJuels"); err != nil {
+		return nil, err
+	}
+	if cfg.floorPPB, err = integerOption(opts, "floorPPB"); err != nil {
+		return nil, err
+	}
+	if cfg.capPPB, err = integerOption(opts, "capPPB"); err != nil {
+		return nil, err
+	}
+	if cfg.floorPPB > cfg.capPPB {
+		return nil, fmt.Errorf("invalid 'floorPPB' field in deviation function definition: %d exceeds 'capPPB' %d", cfg.floorPPB, cfg.capPPB)
+	}
+	return makeGasAwareDeviationFunc(lggr, cfg), nil
+}
+
+// transmissionCostJuels is the cost of one transmission in juels:
+// gasPerTransmission * gasPriceSubunits / feeCoinUnit * juelsPerFeeCoin.
+func (c gasCostConfig) transmissionCostJuels(juelsPerFeeCoin, gasPriceSubunits *big.Int) *big.Rat {
+	cost := new(big.Int).Mul(c.gasPerTransmission, gasPriceSubunits)
+	cost.Mul(cost, juelsPerFeeCoin)
+	return new(big.Rat).SetFrac(cost, c.feeCoinUnit)
+}
+
+// breakEvenPPB is the relative deviation, in PPB rounded up, at which the value
+// at risk equals the cost of a transmission.
+func (c gasCostConfig) breakEvenPPB(costJuels *big.Rat) *big.Int {
+	ppb := new(big.Rat).Mul(costJuels, new(big.Rat).SetInt64(1e9))
+	ppb.Quo(ppb, new(big.Rat).SetInt(c.valueAtRiskJuels))
+	q, r := new(big.Int).QuoRem(ppb.Num(), ppb.Denom(), new(big.Int))
+	if r.Sign() != 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+// makeGasAwareDeviationFunc makes a relative deviation func whose threshold is
+// the deviation at which updating is worth its gas: the threshold rises when
+// gas is expensive and falls when it is cheap, bounded by floorPPB and capPPB.
+// The configured thresholdPPB is not used.
+//
+// Gas price and juels per fee coin are the aggregated observations of the report
+// being evaluated, so this only works inside the reporting plugin, and only with
+// a report codec able to read the fees back out of reports for the accept stage.
+func makeGasAwareDeviationFunc(lggr logger.Logger, cfg gasCostConfig) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		round := deviationRoundFromContext(ctx)
+		if round == nil || round.juelsPerFeeCoin == nil || round.gasPriceSubunits == nil {
+			return false, errors.New("gas-aware deviation requires fee observations, which are only available inside the reporting plugin")
+		}
+
+		costJuels := cfg.transmissionCostJuels(round.juelsPerFeeCoin, round.gasPriceSubunits)
+		breakEvenPPB := cfg.breakEvenPPB(costJuels)
+		effectivePPB := clampPPB(breakEvenPPB, cfg.floorPPB, cfg.capPPB)
+
+		var deviates bool
+		if oldVal.Sign() == 0 {
+			deviates = newVal.Sign() != 0
+		} else {
+			deviates = relativeDeviates(effectivePPB, oldVal, newVal)
+		}
+
+		decision := newDeviationDecision("gasAware", thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["gasPerTransmission"] = cfg.gasPerTransmission.String()
+		decision.Intermediates["gasPriceSubunits"] = round.gasPriceSubunits.String()
+		decision.Intermediates["juelsPerFeeCoin"] = round.juelsPerFeeCoin.String()
+		decision.Intermediates["transmissionCostJuels"] = costJuels.FloatString(0)
+		decision.Intermediates["valueAtRiskJuels"] = cfg.valueAtRiskJuels.String()
+		decision.Intermediates["breakEvenPPB"] = breakEvenPPB.String()
+		decision.Intermediates["floorPPB"] = cfg.floorPPB
+		decision.Intermediates["capPPB"] = cfg.capPPB
+		decision.Intermediates["effectiveThresholdPPB"] = effectivePPB
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
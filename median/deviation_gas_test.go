@@ -0,0 +1,150 @@
+package median
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_GasAware(t *testing.T) {
+	valid := func() map[string]any {
+		return map[string]any{"type": "gasAware", "gasPerTransmission": float64(1e5), "valueAtRiskJuels": "10000000000000000000", "floorPPB": float64(1e5), "capPPB": float64(5e7)}
+	}
+	t.Run("zero gasPerTransmission", func(t *testing.T) {
+		opts := valid()
+		opts["gasPerTransmission"] = float64(0)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'gasPerTransmission' field in deviation function definition: must be positive")
+	})
+	t.Run("zero valueAtRiskJuels", func(t *testing.T) {
+		opts := valid()
+		opts["valueAtRiskJuels"] = "0"
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'valueAtRiskJuels' field in deviation function definition: must be positive, got 0")
+	})
+	t.Run("floor exceeds cap", func(t *testing.T) {
+		opts := valid()
+		opts["floorPPB"] = float64(6e7)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'floorPPB' field in deviation function definition: 60000000 exceeds 'capPPB' 50000000")
+	})
+	t.Run("invalid feeCoinDecimals", func(t *testing.T) {
+		opts := valid()
+		opts["feeCoinDecimals"] = float64(-1)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "missing or invalid 'feeCoinDecimals' field in deviation function definition")
+
+		opts["feeCoinDecimals"] = float64(78)
+		_, err = newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'feeCoinDecimals' field in deviation function definition: 10^78 exceeds 256 bits")
+	})
+	t.Run("requires round data", func(t *testing.T) {
+		f, err := newDeviationFunc(t, valid())
+		require.NoError(t, err)
+		_, err = f(nil, 1e7, big.NewInt(1000), big.NewInt(1010))
+		require.EqualError(t, err, "gas-aware deviation requires fee observations, which are only available inside the reporting plugin")
+	})
+}
+
+func Test_GasAwareDeviationFunc(t *testing.T) {
+	// One transmission costs 1e5 gas * gasPrice * 10 LINK per fee coin, and 10 LINK
+	// are at risk per unit of relative deviation, so at a gas price of 10 gwei the
+	// break even deviation is 1e16 / 1e19 = 0.1%.
+	cfg := gasCostConfig{
+		gasPerTransmission: big.NewInt(1e5),
+		feeCoinUnit:        big.NewInt(1e18),
+		valueAtRiskJuels:   valFromString(t, "10"),
+		floorPPB:           1e5,
+		capPPB:             5e7,
+	}
+	juelsPerFeeCoin := valFromString(t, "10")
+
+	tcs := []struct {
+		name string
+
+		gasPriceSubunits *big.Int
+		oldVal           *big.Int
+		newVal           *big.Int
+
+		expectedThresholdPPB uint64
+		expected             bool
+	}{
+		{
+			name:                 "at break even - SHOULD UPDATE",
+			gasPriceSubunits:     big.NewInt(1e10),
+			oldVal:               big.NewInt(1000),
+			newVal:               big.NewInt(1001),
+			expectedThresholdPPB: 1e6,
+			expected:             true,
+		},
+		{
+			name:                 "expensive gas raises threshold - SHOULD NOT UPDATE",
+			gasPriceSubunits:     big.NewInt(1e11),
+			oldVal:               big.NewInt(1000),
+			newVal:               big.NewInt(1009),
+			expectedThresholdPPB: 1e7,
+			expected:             false,
+		},
+		{
+			name:                 "cheap gas lowers threshold - SHOULD UPDATE",
+			gasPriceSubunits:     big.NewInt(2e9),
+			oldVal:               big.NewInt(10000),
+			newVal:               big.NewInt(9998),
+			expectedThresholdPPB: 2e5,
+			expected:             true,
+		},
+		{
+			name:                 "zero gas price uses floor - SHOULD NOT UPDATE",
+			gasPriceSubunits:     big.NewInt(0),
+			oldVal:               big.NewInt(100000),
+			newVal:               big.NewInt(100009),
+			expectedThresholdPPB: 1e5,
+			expected:             false,
+		},
+		{
+			name:                 "extreme gas price is capped - SHOULD UPDATE",
+			gasPriceSubunits:     big.NewInt(1e15),
+			oldVal:               big.NewInt(1000),
+			newVal:               big.NewInt(1050),
+			expectedThresholdPPB: 5e7,
+			expected:             true,
+		},
+		{
+			name:                 "break even rounds up - SHOULD NOT UPDATE",
+			gasPriceSubunits:     big.NewInt(10_000_000_001),
+			oldVal:               big.NewInt(1000),
+			newVal:               big.NewInt(1001),
+			expectedThresholdPPB: 1_000_001,
+			expected:             false,
+		},
+		{
+			name:                 "zero old value - SHOULD UPDATE",
+			gasPriceSubunits:     big.NewInt(1e10),
+			oldVal:               big.NewInt(0),
+			newVal:               big.NewInt(1),
+			expectedThresholdPPB: 1e6,
+			expected:             true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			lggr, logs := logger.TestObserved(t, zapcore.DebugLevel)
+			ctx := withDeviationRound(context.Background(), &deviationRound{juelsPerFeeCoin: juelsPerFeeCoin, gasPriceSubunits: tc.gasPriceSubunits})
+
+			actual, err := makeGasAwareDeviationFunc(lggr, cfg)(ctx, 1e7, tc.oldVal, tc.newVal)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+
+			entries := logs.FilterMessage("DeviationDecision").All()
+			require.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedThresholdPPB, entries[0].ContextMap()["effectiveThresholdPPB"])
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+type hysteresisConfig struct {
+	// consecutive is the number of evaluations in a row the inner func must fire, 0 if unused
+	consecutive uint64
+	// minDuration is how long the inner func must keep firing, 0 if unused
+	minDuration time.Duration
+	timeSource  TimeSource
+}
+
+func newHysteresisDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	innerOpts, ok := opts["function"].(map[string]any)
+	if !ok {
+		return nil, errors.New("missing or invalid 'function' field in deviation function definition")
+	}
+	if compositeDepth(opts) > MaxCompositeDepth {
+		return nil, fmt.Errorf("invalid 'function' field in deviation function definition: nesting exceeds %d levels", MaxCompositeDepth)
+	}
+	inner, err := NewDeviationFunc(lggr, innerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'function' field in deviation function definition: %w", err)
+	}
+
+	var cfg hysteresisConfig
+	_, hasConsecutive := opts["consecutive"]
+	_, hasDuration := opts["minDurationSeconds"]
+	if hasConsecutive == hasDuration {
+		return nil, errors.New("exactly one of 'consecutive' and 'minDurationSeconds' must be set in deviation function definition")
+	}
+	if hasConsecutive {
+		if cfg.consecutive, err = integerOption(opts, "consecutive"); err != nil {
+			return nil, err
+		}
+		if cfg.consecutive == 0 {
+			return nil, errors.New("invalid 'consecutive' field in deviation function definition: must be positive")
+		}
+	} else {
+		seconds, err := integerOption(opts, "minDurationSeconds")
+		if err != nil {
+			return nil, err
+		}
+		if seconds == 0 || seconds > uint64(maxHysteresisDuration/time.Second) {
+			return nil, fmt.Errorf("invalid 'minDurationSeconds' field in deviation function definition: must be between 1 and %d", uint64(maxHysteresisDuration/time.Second))
+		}
+		cfg.minDuration = time.Duration(seconds) * time.Second
+	}
+	if cfg.timeSource, err = parseTimeSource(opts); err != nil {
+		return nil, err
+	}
+
+	return makeHysteresisDeviationFunc(lggr, inner, SystemClock{}, cfg), nil
+}
+
+const maxHysteresisDuration = 7 * 24 * time.Hour
+
+// hysteresisState tracks for how long the inner func has been firing. It is
+// keyed by config digest and cleared whenever a new transmission shows up on-chain.
+type hysteresisState struct {
+	mu           sync.Mutex
+	configDigest ocrtypes.ConfigDigest
+	transmission transmissionKey
+	streak       uint64
+	streakStart  time.Time
+}
+
+type transmissionKey struct {
+	configDigest ocrtypes.ConfigDigest
+	epoch        uint32
+	round        uint8
+}
+
+// sync clears the streak if the round belongs to another config or a report
+// has been transmitted since the last evaluation.
+func (s *hysteresisState) sync(round *deviationRound) {
+	if round == nil {
+		return
+	}
+	if round.configDigest != s.configDigest {
+		s.configDigest = round.configDigest
+		s.streak, s.streakStart = 0, time.Time{}
+	}
+	if t := round.getLatestTransmission(); t != nil {
+		key := transmissionKey{t.configDigest, t.epoch, t.round}
+		if key != s.transmission {
+			s.transmission = key
+			s.streak, s.streakStart = 0, time.Time{}
+		}
+	}
+}
+
+// makeHysteresisDeviationFunc makes a deviation func that only fires once inner
+// has fired for cfg.consecutive evaluations in a row, or without interruption for
+// cfg.minDuration, so single noisy values do not cause an update.
+//
+// Only the report stage of the reporting plugin counts towards the streak. When
+// accepting an already built report, the inner func's result is used as is since
+// the report only exists because the streak was complete.
+func makeHysteresisDeviationFunc(lggr logger.Logger, inner median.DeviationFunc, clock Clock, cfg hysteresisConfig) median.DeviationFunc {
+	state := &hysteresisState{}
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		innerDeviates, err := inner(ctx, thresholdPPB, oldVal, newVal)
+		if err != nil {
+			return false, err
+		}
+
+		round := deviationRoundFromContext(ctx)
+		if round != nil && round.stage == deviationStageAccept {
+			return innerDeviates, nil
+		}
+
+		var now time.Time
+		if cfg.minDuration > 0 {
+			if now, err = deviationNow(ctx, clock, cfg.timeSource); err != nil {
+				return false, err
+			}
+		}
+
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		state.sync(round)
+
+		if !innerDeviates {
+			state.streak, state.streakStart = 0, time.Time{}
+		} else {
+			if state.streak == 0 {
+				state.streakStart = now
+			}
+			state.streak++
+		}
+
+		var deviates bool
+		if cfg.consecutive > 0 {
+			deviates = state.streak >= cfg.consecutive
+		} else {
+			deviates = state.streak > 0 && now.Sub(state.streakStart) >= cfg.minDuration
+		}
+
+		decision := newDeviationDecision("hysteresis", thresholdPPB, oldVal, newVal)
+		decision.Time, decision.Deviates = now, deviates
+		decision.Intermediates["innerDeviates"] = innerDeviates
+		decision.Intermediates["streak"] = state.streak
+		decision.Intermediates["streakStart"] = state.streakStart
+		decision.Intermediates["consecutive"] = cfg.consecutive
+		decision.Intermediates["minDuration"] = cfg.minDuration.String()
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
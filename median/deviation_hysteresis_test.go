@@ -0,0 +1,183 @@
+package median
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_Hysteresis(t *testing.T) {
+	inner := map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate"}
+	t.Run("missing function", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "consecutive": float64(2)})
+		require.EqualError(t, err, "missing or invalid 'function' field in deviation function definition")
+	})
+	t.Run("invalid function", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "consecutive": float64(2), "function": map[string]any{"type": "relative"}})
+		require.EqualError(t, err, "invalid 'function' field in deviation function definition: invalid deviation function definition: #: missing properties: 'zeroFloor'; #: missing properties: 'zeroPolicy'")
+	})
+	t.Run("neither consecutive nor minDurationSeconds", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "function": inner})
+		require.EqualError(t, err, "exactly one of 'consecutive' and 'minDurationSeconds' must be set in deviation function definition")
+	})
+	t.Run("both consecutive and minDurationSeconds", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "function": inner, "consecutive": float64(2), "minDurationSeconds": float64(60)})
+		require.EqualError(t, err, "exactly one of 'consecutive' and 'minDurationSeconds' must be set in deviation function definition")
+	})
+	t.Run("zero consecutive", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "function": inner, "consecutive": float64(0)})
+		require.EqualError(t, err, "invalid 'consecutive' field in deviation function definition: must be positive")
+	})
+	t.Run("minDurationSeconds too large", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "function": inner, "minDurationSeconds": float64(604801)})
+		require.EqualError(t, err, "invalid 'minDurationSeconds' field in deviation function definition: must be between 1 and 604800")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "hysteresis", "function": inner, "consecutive": float64(2)})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1000), big.NewInt(1010))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+		deviates, err = f(nil, 1e7, big.NewInt(1000), big.NewInt(1010))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+}
+
+// hysteresisStep is one evaluation of the hysteresis wrapper.
+type hysteresisStep struct {
+	innerDeviates bool
+	// advance moves the clock forward before evaluating
+	advance time.Duration
+	// round is attached to the context if non-nil
+	round *deviationRound
+
+	expected bool
+}
+
+func Test_HysteresisDeviationFunc(t *testing.T) {
+	digestA := ocrtypes.ConfigDigest{1}
+	digestB := ocrtypes.ConfigDigest{2}
+	reportRound := func(digest ocrtypes.ConfigDigest, epoch uint32) *deviationRound {
+		r := &deviationRound{configDigest: digest, stage: deviationStageReport}
+		r.setLatestTransmission(transmissionDetails{configDigest: digest, epoch: epoch})
+		return r
+	}
+	acceptRound := &deviationRound{configDigest: digestA, stage: deviationStageAccept}
+
+	tcs := []struct {
+		name  string
+		cfg   hysteresisConfig
+		steps []hysteresisStep
+	}{
+		{
+			name: "fires after K consecutive evaluations",
+			cfg:  hysteresisConfig{consecutive: 3},
+			steps: []hysteresisStep{
+				{innerDeviates: true, expected: false},
+				{innerDeviates: true, expected: false},
+				{innerDeviates: true, expected: true},
+				{innerDeviates: true, expected: true},
+			},
+		},
+		{
+			name: "interrupted streak starts over",
+			cfg:  hysteresisConfig{consecutive: 2},
+			steps: []hysteresisStep{
+				{innerDeviates: true, expected: false},
+				{innerDeviates: false, expected: false},
+				{innerDeviates: true, expected: false},
+				{innerDeviates: true, expected: true},
+			},
+		},
+		{
+			name: "K of 1 behaves like the inner func",
+			cfg:  hysteresisConfig{consecutive: 1},
+			steps: []hysteresisStep{
+				{innerDeviates: true, expected: true},
+				{innerDeviates: false, expected: false},
+			},
+		},
+		{
+			name: "fires once deviation persisted for the minimum duration",
+			cfg:  hysteresisConfig{minDuration: time.Minute, timeSource: TimeSourceSystem},
+			steps: []hysteresisStep{
+				{innerDeviates: true, expected: false},
+				{innerDeviates: true, advance: 59 * time.Second, expected: false},
+				{innerDeviates: true, advance: time.Second, expected: true},
+				{innerDeviates: false, advance: time.Second, expected: false},
+				{innerDeviates: true, advance: time.Minute, expected: false},
+			},
+		},
+		{
+			name: "new transmission clears the streak",
+			cfg:  hysteresisConfig{consecutive: 2},
+			steps: []hysteresisStep{
+				{innerDeviates: true, round: reportRound(digestA, 1), expected: false},
+				{innerDeviates: true, round: reportRound(digestA, 1), expected: true},
+				{innerDeviates: true, round: reportRound(digestA, 2), expected: false},
+				{innerDeviates: true, round: reportRound(digestA, 2), expected: true},
+			},
+		},
+		{
+			name: "config digest change clears the streak",
+			cfg:  hysteresisConfig{consecutive: 2},
+			steps: []hysteresisStep{
+				{innerDeviates: true, round: reportRound(digestA, 1), expected: false},
+				{innerDeviates: true, round: reportRound(digestB, 1), expected: false},
+				{innerDeviates: true, round: reportRound(digestB, 1), expected: true},
+			},
+		},
+		{
+			name: "accepting a report neither counts nor resets the streak",
+			cfg:  hysteresisConfig{consecutive: 2},
+			steps: []hysteresisStep{
+				{innerDeviates: true, round: reportRound(digestA, 1), expected: false},
+				{innerDeviates: true, round: acceptRound, expected: true},
+				{innerDeviates: false, round: acceptRound, expected: false},
+				{innerDeviates: true, round: reportRound(digestA, 1), expected: true},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var innerDeviates bool
+			inner := func(context.Context, uint64, *big.Int, *big.Int) (bool, error) {
+				return innerDeviates, nil
+			}
+			clock := &mutableClock{now: frozenTimeClock{}.Now()}
+			f := makeHysteresisDeviationFunc(logger.Test(t), inner, clock, tc.cfg)
+
+			for i, step := range tc.steps {
+				innerDeviates = step.innerDeviates
+				clock.now = clock.now.Add(step.advance)
+				ctx := context.Background()
+				if step.round != nil {
+					ctx = withDeviationRound(ctx, step.round)
+				}
+				actual, err := f(ctx, 1e7, big.NewInt(1000), big.NewInt(1010))
+				require.NoError(t, err, "step %d", i)
+				assert.Equal(t, step.expected, actual, "step %d", i)
+			}
+		})
+	}
+
+	t.Run("inner errors are returned", func(t *testing.T) {
+		f := makeHysteresisDeviationFunc(logger.Test(t), constDeviationFunc(false, assert.AnError), SystemClock{}, hysteresisConfig{consecutive: 1})
+		_, err := f(nil, 1e7, big.NewInt(1000), big.NewInt(1010))
+		require.ErrorIs(t, err, assert.AnError)
+	})
+	t.Run("nil values error", func(t *testing.T) {
+		f := makeHysteresisDeviationFunc(logger.Test(t), constDeviationFunc(true, nil), SystemClock{}, hysteresisConfig{consecutive: 1})
+		_, err := f(nil, 1e7, nil, big.NewInt(1010))
+		require.EqualError(t, err, "oldVal and newVal must be non-nil")
+	})
+}
@@ -0,0 +1,234 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+	// Embedded zone data, so every oracle resolves the calendar timezone the same way whatever the host has installed.
+	_ "time/tzdata"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+const (
+	// MaxMarketSessions bounds the number of weekly sessions in a trading calendar.
+	MaxMarketSessions = 64
+	// MaxMarketHolidays bounds the number of holidays in a trading calendar.
+	MaxMarketHolidays = 2000
+)
+
+// marketSession is a weekly trading session. close before open means the
+// session runs past midnight into the next day.
+type marketSession struct {
+	days  [7]bool // indexed by time.Weekday
+	open  time.Duration
+	close time.Duration
+}
+
+// tradingCalendar decides whether a market is open at a given time.
+type tradingCalendar struct {
+	location *time.Location
+	sessions []marketSession
+	// holidays are dates, as YYYY-MM-DD in location, on which no session opens
+	holidays map[string]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func newMarketHoursDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	cal, err := parseTradingCalendar(opts)
+	if err != nil {
+		return nil, err
+	}
+	var offHoursPPB *uint64
+	if offHoursPPB, err = optionalThresholdPPB(opts, "offHoursThresholdPPB"); err != nil {
+		return nil, err
+	}
+	timeSource, err := parseTimeSource(opts)
+	if err != nil {
+		return nil, err
+	}
+	return makeMarketHoursDeviationFunc(lggr, SystemClock{}, timeSource, cal, offHoursPPB), nil
+}
+
+func parseTradingCalendar(opts map[string]any) (tradingCalendar, error) {
+	var cal tradingCalendar
+	tz, ok := opts["timezone"].(string)
+	if !ok {
+		return cal, errors.New("missing or invalid 'timezone' field in deviation function definition")
+	}
+	// LoadLocation reads "" as UTC and "Local" as the zone of the node, which
+	// would make the calendar differ between the nodes of a DON.
+	if tz == "" || tz == "Local" {
+		return cal, fmt.Errorf("invalid 'timezone' field in deviation function definition: %q is not an IANA time zone", tz)
+	}
+	var err error
+	if cal.location, err = time.LoadLocation(tz); err != nil {
+		return cal, fmt.Errorf("invalid 'timezone' field in deviation function definition: %w", err)
+	}
+
+	sessions, ok := opts["sessions"].([]any)
+	if !ok || len(sessions) == 0 {
+		return cal, errors.New("missing or invalid 'sessions' field in deviation function definition")
+	}
+	if len(sessions) > MaxMarketSessions {
+		return cal, fmt.Errorf("invalid 'sessions' field in deviation function definition: more than %d sessions", MaxMarketSessions)
+	}
+	for i, s := range sessions {
+		session, err := parseMarketSession(s)
+		if err != nil {
+			return cal, fmt.Errorf("invalid 'sessions[%d]' field in deviation function definition: %w", i, err)
+		}
+		cal.sessions = append(cal.sessions, session)
+	}
+
+	cal.holidays = map[string]bool{}
+	if v, ok := opts["holidays"]; ok {
+		holidays, ok := v.([]any)
+		if !ok {
+			return cal, errors.New("missing or invalid 'holidays' field in deviation function definition")
+		}
+		if len(holidays) > MaxMarketHolidays {
+			return cal, fmt.Errorf("invalid 'holidays' field in deviation function definition: more than %d holidays", MaxMarketHolidays)
+		}
+		for i, h := range holidays {
+			date, ok := h.(string)
+			if !ok {
+				return cal, fmt.Errorf("invalid 'holidays[%d]' field in deviation function definition", i)
+			}
+			if _, err := time.Parse(time.DateOnly, date); err != nil {
+				return cal, fmt.Errorf("invalid 'holidays[%d]' field in deviation function definition: %s", i, date)
+			}
+			cal.holidays[date] = true
+		}
+	}
+	return cal, nil
+}
+
+func parseMarketSession(v any) (marketSession, error) {
+	var session marketSession
+	m, ok := v.(map[string]any)
+	if !ok {
+		return session, errors.New("must be an object")
+	}
+	days, ok := m["days"].([]any)
+	if !ok || len(days) == 0 {
+		return session, errors.New("missing or invalid 'days'")
+	}
+	for _, d := range days {
+		name, _ := d.(string)
+		day, ok := weekdays[name]
+		if !ok {
+			return session, fmt.Errorf("invalid day %v", d)
+		}
+		session.days[day] = true
+	}
+	var err error
+	if session.open, err = parseTimeOfDay(m, "open"); err != nil {
+		return session, err
+	}
+	if session.open == 24*time.Hour {
+		return session, errors.New("invalid 'open': 24:00")
+	}
+	if session.close, err = parseTimeOfDay(m, "close"); err != nil {
+		return session, err
+	}
+	if session.open == session.close {
+		return session, errors.New("'open' and 'close' must differ")
+	}
+	return session, nil
+}
+
+// parseTimeOfDay reads an HH:MM time of day. 24:00 is accepted as the end of the day.
+func parseTimeOfDay(m map[string]any, key string) (time.Duration, error) {
+	s, ok := m[key].(string)
+	if !ok {
+		return 0, fmt.Errorf("missing or invalid '%s'", key)
+	}
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s': %s", key, s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// isOpen reports whether any session is open at t.
+func (c tradingCalendar) isOpen(t time.Time) bool {
+	t = t.In(c.location)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.location)
+	yesterday := today.AddDate(0, 0, -1)
+	// Wall-clock time of day, which differs from the time elapsed since midnight on DST transition days.
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for _, s := range c.sessions {
+		if s.close > s.open {
+			if c.opensOn(s, today) && sinceMidnight >= s.open && sinceMidnight < s.close {
+				return true
+			}
+			continue
+		}
+		// Overnight session, either opened today or still running from yesterday.
+		if c.opensOn(s, today) && sinceMidnight >= s.open {
+			return true
+		}
+		if c.opensOn(s, yesterday) && sinceMidnight < s.close {
+			return true
+		}
+	}
+	return false
+}
+
+func (c tradingCalendar) opensOn(s marketSession, day time.Time) bool {
+	return s.days[day.Weekday()] && !c.holidays[day.Format(time.DateOnly)]
+}
+
+// makeMarketHoursDeviationFunc makes a deviation func that applies the default
+// relative check during trading sessions. Outside sessions it applies
+// offHoursThresholdPPB instead, or never fires if that is nil, since off-hours
+// quotes are mostly noise.
+func makeMarketHoursDeviationFunc(lggr logger.Logger, clock Clock, timeSource TimeSource, cal tradingCalendar, offHoursThresholdPPB *uint64) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		now, err := deviationNow(ctx, clock, timeSource)
+		if err != nil {
+			return false, err
+		}
+		open := cal.isOpen(now)
+
+		var deviates bool
+		effectivePPB := thresholdPPB
+		switch {
+		case open:
+			deviates, err = median.DefaultDeviationFunc(ctx, thresholdPPB, oldVal, newVal)
+		case offHoursThresholdPPB != nil:
+			effectivePPB = *offHoursThresholdPPB
+			deviates, err = median.DefaultDeviationFunc(ctx, effectivePPB, oldVal, newVal)
+		default:
+			// Updates only happen on heartbeat while the market is closed
+		}
+		if err != nil {
+			return false, err
+		}
+
+		decision := newDeviationDecision("marketHours", thresholdPPB, oldVal, newVal)
+		decision.Time, decision.Deviates = now, deviates
+		decision.Intermediates["timeSource"] = timeSource
+		decision.Intermediates["marketOpen"] = open
+		decision.Intermediates["effectiveThresholdPPB"] = effectivePPB
+		decision.Intermediates["suppressed"] = !open && offHoursThresholdPPB == nil
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
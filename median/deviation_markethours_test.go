@@ -0,0 +1,227 @@
+package median
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_MarketHours(t *testing.T) {
+	sessions := []any{map[string]any{"days": []any{"mon", "tue", "wed", "thu", "fri"}, "open": "09:30", "close": "16:00"}}
+	t.Run("missing timezone", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "sessions": sessions})
+		require.EqualError(t, err, "missing or invalid 'timezone' field in deviation function definition")
+	})
+	t.Run("local or empty timezone", func(t *testing.T) {
+		for _, tz := range []string{"Local", ""} {
+			_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": tz, "sessions": sessions})
+			require.EqualError(t, err, fmt.Sprintf("invalid 'timezone' field in deviation function definition: %q is not an IANA time zone", tz))
+			require.Error(t, ValidateDeviationDefinition(map[string]any{"type": "marketHours", "timezone": tz, "sessions": sessions}))
+		}
+	})
+	t.Run("unknown timezone", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "Mars/Olympus_Mons", "sessions": sessions})
+		require.ErrorContains(t, err, "invalid 'timezone' field in deviation function definition: ")
+	})
+	t.Run("missing sessions", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "UTC"})
+		require.EqualError(t, err, "missing or invalid 'sessions' field in deviation function definition")
+	})
+	t.Run("invalid day", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "UTC", "sessions": []any{map[string]any{"days": []any{"monday"}, "open": "09:30", "close": "16:00"}}})
+		require.EqualError(t, err, "invalid 'sessions[0]' field in deviation function definition: invalid day monday")
+	})
+	t.Run("invalid open", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "UTC", "sessions": []any{map[string]any{"days": []any{"mon"}, "open": "9:30am", "close": "16:00"}}})
+		require.EqualError(t, err, "invalid 'sessions[0]' field in deviation function definition: invalid 'open': 9:30am")
+	})
+	t.Run("empty session", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "UTC", "sessions": []any{map[string]any{"days": []any{"mon"}, "open": "09:30", "close": "09:30"}}})
+		require.EqualError(t, err, "invalid 'sessions[0]' field in deviation function definition: 'open' and 'close' must differ")
+	})
+	t.Run("invalid holiday", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "UTC", "sessions": sessions, "holidays": []any{"2024-13-01"}})
+		require.EqualError(t, err, "invalid 'holidays[0]' field in deviation function definition: 2024-13-01")
+	})
+	t.Run("valid", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "marketHours", "timezone": "America/New_York", "sessions": sessions, "holidays": []any{"2024-12-25"}, "offHoursThresholdPPB": float64(5e7), "clock": "observationTimestamp"})
+		require.NoError(t, err)
+	})
+}
+
+func Test_MarketHoursDeviationFunc(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	cal, err := parseTradingCalendar(map[string]any{
+		"timezone": "America/New_York",
+		"sessions": []any{
+			map[string]any{"days": []any{"mon", "tue", "wed", "thu", "fri"}, "open": "09:30", "close": "16:00"},
+			// Evening session running past midnight
+			map[string]any{"days": []any{"mon"}, "open": "20:00", "close": "02:00"},
+		},
+		"holidays": []any{"2024-12-25"},
+	})
+	require.NoError(t, err)
+	at := func(s string) time.Time {
+		ts, err := time.ParseInLocation(time.DateTime, s, newYork)
+		require.NoError(t, err)
+		return ts
+	}
+
+	tcs := []struct {
+		name string
+
+		now          time.Time
+		offHoursPPB  *uint64
+		thresholdPPB uint64
+		oldVal       *big.Int
+		newVal       *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:   "nil oldVal errors",
+			now:    at("2024-12-23 10:00:00"),
+			oldVal: nil,
+			newVal: big.NewInt(2),
+			err:    "oldVal and newVal must be non-nil",
+		},
+		{
+			name:         "in session, above threshold - SHOULD UPDATE",
+			now:          at("2024-12-23 10:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1011),
+			expected:     true,
+		},
+		{
+			name:         "in session, below threshold - SHOULD NOT UPDATE",
+			now:          at("2024-12-23 10:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1009),
+			expected:     false,
+		},
+		{
+			name:         "at open - SHOULD UPDATE",
+			now:          at("2024-12-23 09:30:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1011),
+			expected:     true,
+		},
+		{
+			name:         "at close is off-hours - SHOULD NOT UPDATE",
+			now:          at("2024-12-23 16:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(2000),
+			expected:     false,
+		},
+		{
+			name:         "weekend - SHOULD NOT UPDATE",
+			now:          at("2024-12-21 12:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(2000),
+			expected:     false,
+		},
+		{
+			name:         "holiday - SHOULD NOT UPDATE",
+			now:          at("2024-12-25 12:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(2000),
+			expected:     false,
+		},
+		{
+			name:         "overnight session after midnight - SHOULD UPDATE",
+			now:          at("2024-12-24 01:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1011),
+			expected:     true,
+		},
+		{
+			name:         "overnight session does not open on other days - SHOULD NOT UPDATE",
+			now:          at("2024-12-25 01:00:00"),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(2000),
+			expected:     false,
+		},
+		{
+			name:         "session evaluated in market time zone - SHOULD UPDATE",
+			now:          time.Date(2024, 12, 23, 15, 0, 0, 0, time.UTC),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1011),
+			expected:     true,
+		},
+		{
+			name:         "off-hours threshold, below - SHOULD NOT UPDATE",
+			now:          at("2024-12-21 12:00:00"),
+			offHoursPPB:  ptr[uint64](5e7),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1049),
+			expected:     false,
+		},
+		{
+			name:         "off-hours threshold, above - SHOULD UPDATE",
+			now:          at("2024-12-21 12:00:00"),
+			offHoursPPB:  ptr[uint64](5e7),
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(1000),
+			newVal:       big.NewInt(1051),
+			expected:     true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f := makeMarketHoursDeviationFunc(logger.Test(t), &mutableClock{now: tc.now}, TimeSourceSystem, cal, tc.offHoursPPB)
+			actual, err := f(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_tradingCalendar_isOpen_DST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	// FX week opening Sunday 17:00 New York time
+	cal, err := parseTradingCalendar(map[string]any{
+		"timezone": "America/New_York",
+		"sessions": []any{map[string]any{"days": []any{"sun"}, "open": "17:00", "close": "24:00"}},
+	})
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name     string
+		now      time.Time
+		expected bool
+	}{
+		{name: "spring forward, after open", now: time.Date(2024, 3, 10, 17, 30, 0, 0, newYork), expected: true},
+		{name: "spring forward, before open", now: time.Date(2024, 3, 10, 16, 30, 0, 0, newYork), expected: false},
+		{name: "fall back, after open", now: time.Date(2024, 11, 3, 17, 30, 0, 0, newYork), expected: true},
+		{name: "fall back, before open", now: time.Date(2024, 11, 3, 16, 30, 0, 0, newYork), expected: false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, cal.isOpen(tc.now))
+		})
+	}
+}
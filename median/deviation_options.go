@@ -0,0 +1,131 @@
+package median
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+)
+
+// MaxBigIntOptionBits bounds the bit length of big integer fields in deviation
+// function definitions.
+const MaxBigIntOptionBits = 256
+
+// MaxDecimals bounds decimals fields in deviation function definitions, the
+// largest number of decimals whose unit 10^decimals fits in MaxBigIntOptionBits.
+const MaxDecimals = 77
+
+// decimalsOption reads a number of decimals, an integer between 0 and
+// MaxDecimals. JSON numbers are decoded as float64.
+func decimalsOption(opts map[string]any, key string) (uint64, error) {
+	f, ok := opts[key].(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return 0, fmt.Errorf("missing or invalid '%s' field in deviation function definition", key)
+	}
+	if f > MaxDecimals {
+		return 0, fmt.Errorf("invalid '%s' field in deviation function definition: 10^%v exceeds %d bits", key, f, MaxBigIntOptionBits)
+	}
+	return uint64(f), nil
+}
+
+// MaxDecimalStringLength bounds the length of decimal strings in deviation
+// function definitions, see ParseDecimal.
+const MaxDecimalStringLength = 100
+
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// ParseDecimal parses a plain base 10 number such as -12.5, of at most maxLen
+// characters. Unlike big.Rat.SetString it rejects exponents, fractions and other
+// bases, and it checks the string before parsing, so an input like 1e1000000000
+// is never expanded.
+func ParseDecimal(s string, maxLen int) (*big.Rat, bool) {
+	if len(s) > maxLen || !decimalPattern.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// maxSafeJSONInteger is the largest integer a JSON number decoded as float64 represents exactly.
+const maxSafeJSONInteger = 1<<53 - 1
+
+// integerOption reads a non-negative integer field, such as a threshold in parts
+// per billion. JSON numbers are decoded as float64, which bounds the range.
+func integerOption(opts map[string]any, key string) (uint64, error) {
+	f, ok := opts[key].(float64)
+	if !ok || f < 0 || f > maxSafeJSONInteger || f != float64(uint64(f)) {
+		return 0, fmt.Errorf("missing or invalid '%s' field in deviation function definition", key)
+	}
+	return uint64(f), nil
+}
+
+// maxBigIntOptionLength bounds the length of big integer strings. It is checked
+// before parsing, so huge strings are rejected without converting them. Any
+// longer number exceeds MaxBigIntOptionBits, unless padded with zeros.
+const maxBigIntOptionLength = 100
+
+// bigIntOption reads a big integer field encoded as a base 10 string, since JSON
+// numbers cannot represent it exactly. Its size is bounded by MaxBigIntOptionBits.
+func bigIntOption(opts map[string]any, key string) (*big.Int, error) {
+	s, ok := opts[key].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid '%s' field in deviation function definition", key)
+	}
+	if len(s) > maxBigIntOptionLength {
+		return nil, fmt.Errorf("invalid '%s' field in deviation function definition: exceeds %d bits", key, MaxBigIntOptionBits)
+	}
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid '%s' field in deviation function definition: %s", key, s)
+	}
+	if v.BitLen() > MaxBigIntOptionBits {
+		return nil, fmt.Errorf("invalid '%s' field in deviation function definition: exceeds %d bits", key, MaxBigIntOptionBits)
+	}
+	return v, nil
+}
+
+// positiveBigIntOption reads a strictly positive big integer field, see bigIntOption.
+func positiveBigIntOption(opts map[string]any, key string) (*big.Int, error) {
+	v, err := bigIntOption(opts, key)
+	if err != nil {
+		return nil, err
+	}
+	if v.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid '%s' field in deviation function definition: must be positive, got %s", key, v)
+	}
+	return v, nil
+}
+
+// multiplierOption reads the scale of feed values, given either as a 'multiplier'
+// or as a number of 'decimals'. It returns def if neither is set.
+func multiplierOption(opts map[string]any, def *big.Int) (*big.Int, error) {
+	_, hasMultiplier := opts["multiplier"]
+	_, hasDecimals := opts["decimals"]
+	switch {
+	case hasMultiplier && hasDecimals:
+		return nil, errors.New("only one of 'multiplier' and 'decimals' may be set in deviation function definition")
+	case hasMultiplier:
+		return positiveBigIntOption(opts, "multiplier")
+	case hasDecimals:
+		decimals, err := decimalsOption(opts, "decimals")
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(decimals), nil), nil
+	default:
+		return def, nil
+	}
+}
+
+// finiteFloatOption reads a number field, which must be finite and within [lo, hi].
+func finiteFloatOption(opts map[string]any, key string, lo, hi float64) (float64, error) {
+	v, ok := opts[key]
+	if !ok {
+		return 0, fmt.Errorf("missing or invalid '%s' field in deviation function definition", key)
+	}
+	f, ok := v.(float64)
+	if !ok || math.IsNaN(f) || f < lo || f > hi {
+		return 0, fmt.Errorf("invalid '%s' field in deviation function definition: %v", key, v)
+	}
+	return f, nil
+}
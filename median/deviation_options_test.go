@@ -0,0 +1,42 @@
+package median
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseDecimal(t *testing.T) {
+	tcs := []struct {
+		input    string
+		maxLen   int
+		expected string
+	}{
+		{input: "0", maxLen: 1, expected: "0"},
+		{input: "12.5", maxLen: 4, expected: "25/2"},
+		{input: "-0.001", maxLen: 6, expected: "-1/1000"},
+		{input: "12.5", maxLen: 3},
+		{input: ""},
+		{input: "1e1000000000", maxLen: 100},
+		{input: "1/2", maxLen: 100},
+		{input: "0x10", maxLen: 100},
+		{input: "+1", maxLen: 100},
+		{input: "1.", maxLen: 100},
+		{input: ".5", maxLen: 100},
+		{input: " 1", maxLen: 100},
+		{input: strings.Repeat("9", MaxDecimalStringLength+1), maxLen: MaxDecimalStringLength},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.input, func(t *testing.T) {
+			v, ok := ParseDecimal(tc.input, tc.maxLen)
+			if tc.expected == "" {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tc.expected, v.RatString())
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func newPegBandDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	pegStr, ok := opts["peg"].(string)
+	if !ok {
+		return nil, errors.New("missing or invalid 'peg' field in deviation function definition")
+	}
+	peg, ok := ParseDecimal(pegStr, MaxDecimalStringLength)
+	if !ok || peg.Sign() < 0 {
+		return nil, fmt.Errorf("invalid 'peg' field in deviation function definition: %s", pegStr)
+	}
+	if peg.Sign() == 0 {
+		return nil, errors.New("invalid 'peg' field in deviation function definition: must be positive")
+	}
+	multiplier, err := multiplierOption(opts, DefaultMultiplier)
+	if err != nil {
+		return nil, err
+	}
+	bandPPB, err := integerOption(opts, "bandPPB")
+	if err != nil {
+		return nil, err
+	}
+	if bandPPB == 0 || bandPPB >= 1e9 {
+		return nil, fmt.Errorf("invalid 'bandPPB' field in deviation function definition: must be between 1 and 999999999, got %d", bandPPB)
+	}
+
+	// The band is [peg*(1-band), peg*(1+band)] in feed units
+	pegValue := new(big.Rat).Mul(peg, new(big.Rat).SetInt(multiplier))
+	band := new(big.Rat).SetFrac(new(big.Int).SetUint64(bandPPB), big.NewInt(1e9))
+	lower := new(big.Rat).Mul(pegValue, new(big.Rat).Sub(big.NewRat(1, 1), band))
+	upper := new(big.Rat).Mul(pegValue, new(big.Rat).Add(big.NewRat(1, 1), band))
+
+	return makePegBandDeviationFunc(lggr, lower, upper), nil
+}
+
+// makePegBandDeviationFunc makes a deviation func for pegged assets. It fires when
+// the value leaves, re-enters or jumps over the inclusive band [lower, upper] and
+// ignores any movement inside it. While outside the band on the same side it
+// behaves like the default relative deviation check.
+func makePegBandDeviationFunc(lggr logger.Logger, lower, upper *big.Rat) median.DeviationFunc {
+	// bandPosition is -1 below the band, 0 inside it and 1 above it
+	bandPosition := func(v *big.Int) int {
+		r := new(big.Rat).SetInt(v)
+		switch {
+		case r.Cmp(lower) < 0:
+			return -1
+		case r.Cmp(upper) > 0:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		oldPosition, newPosition := bandPosition(oldVal), bandPosition(newVal)
+
+		var deviates bool
+		var reason string
+		switch {
+		case oldPosition != newPosition:
+			deviates, reason = true, "bandCrossing"
+		case oldPosition == 0:
+			deviates, reason = false, "insideBand"
+		default:
+			var err error
+			if deviates, err = median.DefaultDeviationFunc(ctx, thresholdPPB, oldVal, newVal); err != nil {
+				return false, err
+			}
+			reason = "outsideBand"
+		}
+
+		decision := newDeviationDecision("pegBand", thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["lower"] = lower.FloatString(0)
+		decision.Intermediates["upper"] = upper.FloatString(0)
+		decision.Intermediates["oldPosition"] = oldPosition
+		decision.Intermediates["newPosition"] = newPosition
+		decision.Intermediates["reason"] = reason
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
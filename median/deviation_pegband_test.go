@@ -0,0 +1,140 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_PegBand(t *testing.T) {
+	t.Run("missing peg", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "bandPPB": float64(5e6)})
+		require.EqualError(t, err, "missing or invalid 'peg' field in deviation function definition")
+	})
+	t.Run("peg with exponent", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "peg": "1e1000000000", "bandPPB": float64(5e6)})
+		require.EqualError(t, err, "invalid 'peg' field in deviation function definition: 1e1000000000")
+	})
+	t.Run("zero peg", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "peg": "0.0", "bandPPB": float64(5e6)})
+		require.EqualError(t, err, "invalid 'peg' field in deviation function definition: must be positive")
+	})
+	t.Run("band out of range", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "peg": "1", "bandPPB": float64(1e9)})
+		require.EqualError(t, err, "invalid 'bandPPB' field in deviation function definition: must be between 1 and 999999999, got 1000000000")
+	})
+	t.Run("zero multiplier", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "peg": "1", "bandPPB": float64(5e6), "multiplier": "0"})
+		require.EqualError(t, err, "invalid 'multiplier' field in deviation function definition: must be positive, got 0")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "peg": "1", "bandPPB": float64(5e6), "multiplier": "100000000"})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1_00000000), big.NewInt(99400000))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+	t.Run("valid with decimals", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "pegBand", "peg": "1", "bandPPB": float64(5e6), "decimals": float64(8)})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1_00000000), big.NewInt(99600000))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+}
+
+func Test_PegBandDeviationFunc(t *testing.T) {
+	// A peg of 1.00 with a band of 0.995 to 1.005
+	lower := new(big.Rat).SetInt(valFromString(t, "0.995"))
+	upper := new(big.Rat).SetInt(valFromString(t, "1.005"))
+
+	tcs := []struct {
+		name string
+
+		thresholdPPB uint64
+		oldVal       *big.Int
+		newVal       *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:   "nil oldVal errors",
+			oldVal: nil,
+			newVal: big.NewInt(2),
+			err:    "oldVal and newVal must be non-nil",
+		},
+		{
+			name:         "oscillation inside band - SHOULD NOT UPDATE",
+			thresholdPPB: 1e6,
+			oldVal:       valFromString(t, "0.996"),
+			newVal:       valFromString(t, "1.004"),
+			expected:     false,
+		},
+		{
+			name:         "band edges are inside - SHOULD NOT UPDATE",
+			thresholdPPB: 1e6,
+			oldVal:       valFromString(t, "0.995"),
+			newVal:       valFromString(t, "1.005"),
+			expected:     false,
+		},
+		{
+			name:         "leaving band - SHOULD UPDATE",
+			thresholdPPB: 5e8,
+			oldVal:       valFromString(t, "1.005"),
+			newVal:       valFromString(t, "1.0051"),
+			expected:     true,
+		},
+		{
+			name:         "re-entering band - SHOULD UPDATE",
+			thresholdPPB: 5e8,
+			oldVal:       valFromString(t, "0.9949"),
+			newVal:       valFromString(t, "0.995"),
+			expected:     true,
+		},
+		{
+			name:         "jumping over band - SHOULD UPDATE",
+			thresholdPPB: 5e8,
+			oldVal:       valFromString(t, "0.99"),
+			newVal:       valFromString(t, "1.01"),
+			expected:     true,
+		},
+		{
+			name:         "outside band, relative deviation - SHOULD UPDATE",
+			thresholdPPB: 1e7,
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.94"),
+			expected:     true,
+		},
+		{
+			name:         "outside band, small change - SHOULD NOT UPDATE",
+			thresholdPPB: 1e7,
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.949"),
+			expected:     false,
+		},
+		{
+			name:         "zero old value outside band - SHOULD UPDATE",
+			thresholdPPB: 1e7,
+			oldVal:       big.NewInt(0),
+			newVal:       valFromString(t, "0.5"),
+			expected:     true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := makePegBandDeviationFunc(logger.Test(t), lower, upper)(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
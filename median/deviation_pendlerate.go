@@ -0,0 +1,130 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// maxImpliedRateThresholdBps bounds the 'thresholdBps' field of the pendle-implied-rate type, 10000% a year.
+const maxImpliedRateThresholdBps = 1e6
+
+type pendleRateConfig struct {
+	pendleConfig
+	// thresholdBps is the implied rate change that triggers an update, in basis
+	// points. Nil to derive it from thresholdPPB instead.
+	thresholdBps *float64
+}
+
+func newPendleImpliedRateDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	pendle, err := parsePendleConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg := pendleRateConfig{pendleConfig: pendle}
+	if _, ok := opts["thresholdBps"]; ok {
+		bps, err := finiteFloatOption(opts, "thresholdBps", 0, maxImpliedRateThresholdBps)
+		if err != nil {
+			return nil, err
+		}
+		cfg.thresholdBps = &bps
+	}
+	return makePendleImpliedRateDeviationFunc(lggr, SystemClock{}, cfg), nil
+}
+
+// impliedAPY returns the annualized rate at which a PT priced at price, in units of
+// the underlying, accrues to 1 at expiry: (1/price)^(1/years) - 1. It is computed as
+// e^(-ln(price)/years) - 1 at deviationPrec.
+func impliedAPY(price *big.Float, years *big.Float) (*big.Float, error) {
+	if price.Sign() <= 0 {
+		return nil, fmt.Errorf("implied rate is undefined for non-positive price %s", price.Text('g', 30))
+	}
+	continuous := bigLn(price)
+	continuous.Neg(continuous).Quo(continuous, years)
+	growth, ok := bigExp(continuous)
+	if !ok {
+		return nil, fmt.Errorf("implied rate out of range, continuously compounded rate %s", continuous.Text('g', 10))
+	}
+	return growth.Sub(growth, newFloat().SetInt64(1)), nil
+}
+
+// makePendleImpliedRateDeviationFunc makes a deviation func for Pendle PT feeds that
+// compares implied APYs instead of prices. Both values are converted to the rate
+// they imply over the time left to expiry, and the func fires when the rates
+// differ by more than thresholdBps basis points. Without thresholdBps the
+// on-chain thresholdPPB is read as an absolute rate difference, so 1e5 ppb is 1
+// basis point. A non-positive oldVal, as before the first report, deviates from
+// any other value, and so does any value without a computable rate: a
+// non-positive newVal, or one close enough to expiry that its rate overflows.
+// Expiry, minimum horizon and clock behave as in the pendle type.
+func makePendleImpliedRateDeviationFunc(lggr logger.Logger, clock Clock, cfg pendleRateConfig) median.DeviationFunc {
+	expiry := &expiryTracker{lggr: lggr, cfg: cfg.pendleConfig}
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		now, err := deviationNow(ctx, clock, cfg.timeSource)
+		if err != nil {
+			return false, err
+		}
+		yearsToExpiration, phase := expiry.horizon(ctx, now)
+		if phase == expiryPhaseExpired {
+			return expiredPendleDeviates(ctx, lggr, "pendle-implied-rate", cfg.pendleConfig, now, thresholdPPB, oldVal, newVal)
+		}
+
+		// Nothing has been reported or accepted yet, so any value is news. There is no rate to compare against.
+		if oldVal.Sign() <= 0 {
+			deviates := newVal.Cmp(oldVal) != 0
+			decision := newDeviationDecision("pendle-implied-rate", thresholdPPB, oldVal, newVal)
+			decision.Time, decision.Deviates = now, deviates
+			decision.Intermediates["phase"] = phase.String()
+			decision.Intermediates["reason"] = "nonPositiveOldVal"
+			recordDecision(ctx, lggr, decision)
+			return deviates, nil
+		}
+
+		oldRate, oldErr := impliedAPY(floatFromRatio(oldVal, cfg.multiplier), yearsToExpiration)
+		newRate, newErr := impliedAPY(floatFromRatio(newVal, cfg.multiplier), yearsToExpiration)
+		// A non-positive newVal, or a rate too large to compute close to expiry, has
+		// no rate to compare, so any change is news.
+		if err := errors.Join(oldErr, newErr); err != nil {
+			deviates := newVal.Cmp(oldVal) != 0
+			decision := newDeviationDecision("pendle-implied-rate", thresholdPPB, oldVal, newVal)
+			decision.Time, decision.Deviates = now, deviates
+			decision.Intermediates["phase"] = phase.String()
+			decision.Intermediates["reason"] = "undefinedRate"
+			decision.Intermediates["yearsToExpiration"] = yearsToExpiration.Text('g', 30)
+			decision.Intermediates["error"] = err.Error()
+			recordDecision(ctx, lggr, decision)
+			return deviates, nil
+		}
+		diffBps := newFloat().Sub(newRate, oldRate)
+		diffBps.Abs(diffBps).Mul(diffBps, newFloat().SetInt64(1e4))
+
+		threshold := floatFromRatio(new(big.Int).SetUint64(thresholdPPB), big.NewInt(1e5))
+		if cfg.thresholdBps != nil {
+			threshold = floatFromFloat64(*cfg.thresholdBps)
+		}
+		deviates := diffBps.Cmp(threshold) > 0
+
+		decision := newDeviationDecision("pendle-implied-rate", thresholdPPB, oldVal, newVal)
+		decision.Time, decision.Deviates = now, deviates
+		decision.Intermediates["phase"] = phase.String()
+		decision.Intermediates["timeSource"] = cfg.timeSource
+		decision.Intermediates["valMultiplier"] = cfg.multiplier.String()
+		decision.Intermediates["expiresAt"] = cfg.expiresAt
+		decision.Intermediates["yearsToExpiration"] = yearsToExpiration.Text('g', 30)
+		decision.Intermediates["oldRate"] = oldRate.Text('g', 30)
+		decision.Intermediates["newRate"] = newRate.Text('g', 30)
+		decision.Intermediates["diffBps"] = diffBps.Text('g', 30)
+		decision.Intermediates["thresholdBps"] = threshold.Text('g', 30)
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
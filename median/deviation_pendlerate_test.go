@@ -0,0 +1,221 @@
+package median
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_impliedAPY(t *testing.T) {
+	tcs := []struct {
+		name     string
+		price    string
+		years    string
+		expected string
+	}{
+		{name: "one year", price: "0.95", years: "1", expected: "0.0526315789473684210526315789"},
+		{name: "half a year compounds", price: "0.95", years: "0.5", expected: "0.108033240997229916897506925208"},
+		{name: "two years", price: "0.81", years: "2", expected: "0.111111111111111111111111111111"},
+		{name: "at par", price: "1", years: "0.25", expected: "0"},
+		{name: "above par is negative", price: "1.25", years: "1", expected: "-0.2"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			price, _ := newFloat().SetString(tc.price)
+			years, _ := newFloat().SetString(tc.years)
+			expected, _ := newFloat().SetString(tc.expected)
+			actual, err := impliedAPY(price, years)
+			require.NoError(t, err)
+			diff := newFloat().Sub(actual, expected)
+			assert.True(t, diff.Abs(diff).Cmp(floatFromFloat64(1e-25)) < 0, "expected %s, got %s", tc.expected, actual.Text('g', 30))
+		})
+	}
+
+	t.Run("non-positive price", func(t *testing.T) {
+		_, err := impliedAPY(newFloat(), newFloat().SetInt64(1))
+		require.EqualError(t, err, "implied rate is undefined for non-positive price 0")
+	})
+	t.Run("out of range", func(t *testing.T) {
+		price, _ := newFloat().SetString("0.000000000000000001")
+		_, err := impliedAPY(price, floatFromFloat64(1e-5))
+		require.ErrorContains(t, err, "implied rate out of range")
+	})
+}
+
+func Test_PendleImpliedRateDeviationFunc(t *testing.T) {
+	oneYear := float64(frozenTimeClock{}.Now().Unix()) + SecondsInYear
+	bps := func(v float64) *float64 { return &v }
+	tcs := []struct {
+		name string
+
+		expiresAt    float64
+		thresholdBps *float64
+		postExpiry   PostExpiryPolicy
+		thresholdPPB uint64
+		oldVal       *big.Int
+		newVal       *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:   "nil oldVal errors",
+			newVal: big.NewInt(2),
+			err:    "oldVal and newVal must be non-nil",
+		},
+		{
+			name:      "zero oldVal before the first report deviates",
+			expiresAt: oneYear,
+			oldVal:    big.NewInt(0),
+			newVal:    valFromString(t, "0.95"),
+			expected:  true,
+		},
+		{
+			name:      "zero oldVal and newVal do not deviate",
+			expiresAt: oneYear,
+			oldVal:    big.NewInt(0),
+			newVal:    big.NewInt(0),
+			expected:  false,
+		},
+		{
+			name:      "zero newVal deviates",
+			expiresAt: oneYear,
+			oldVal:    valFromString(t, "0.95"),
+			newVal:    big.NewInt(0),
+			expected:  true,
+		},
+		{
+			name:      "negative newVal deviates",
+			expiresAt: oneYear,
+			oldVal:    valFromString(t, "0.95"),
+			newVal:    big.NewInt(-1),
+			expected:  true,
+		},
+		{
+			// -ln(1e-18) over a second is far beyond the range of bigExp
+			name:         "rate out of range near expiry deviates",
+			expiresAt:    float64(frozenTimeClock{}.Now().Unix()) + 1,
+			thresholdBps: bps(1e6),
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       big.NewInt(1),
+			expected:     true,
+		},
+		{
+			name:         "unchanged value without a rate does not deviate",
+			expiresAt:    float64(frozenTimeClock{}.Now().Unix()) + 1,
+			thresholdBps: bps(1e6),
+			oldVal:       big.NewInt(1),
+			newVal:       big.NewInt(1),
+			expected:     false,
+		},
+		{
+			// 5.263% to 4.998% a year
+			name:         "rate change above thresholdBps",
+			expiresAt:    oneYear,
+			thresholdBps: bps(25),
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.9524"),
+			expected:     true,
+		},
+		{
+			name:         "rate change below thresholdBps",
+			expiresAt:    oneYear,
+			thresholdBps: bps(30),
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.9524"),
+			expected:     false,
+		},
+		{
+			name:         "thresholdPPB read as a rate, 1e7 ppb is 100 bps",
+			expiresAt:    oneYear,
+			thresholdPPB: 1e7,
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.9524"),
+			expected:     false,
+		},
+		{
+			// The same price change moves the rate 4x as much with a quarter of the time left
+			name:         "rate moves more closer to expiry",
+			expiresAt:    float64(frozenTimeClock{}.Now().Unix()) + SecondsInYear/4,
+			thresholdPPB: 1e7,
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.9524"),
+			expected:     true,
+		},
+		{
+			name:         "expired freezes",
+			expiresAt:    float64(frozenTimeClock{}.Now().Unix()) - 1,
+			postExpiry:   PostExpiryFreeze,
+			thresholdBps: bps(1),
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.5"),
+			expected:     false,
+		},
+		{
+			name:         "expired always updates",
+			expiresAt:    float64(frozenTimeClock{}.Now().Unix()) - 1,
+			postExpiry:   PostExpiryAlwaysUpdate,
+			thresholdBps: bps(1e6),
+			oldVal:       valFromString(t, "0.95"),
+			newVal:       valFromString(t, "0.9500001"),
+			expected:     true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f := makePendleImpliedRateDeviationFunc(logger.Test(t), frozenTimeClock{}, pendleRateConfig{
+				pendleConfig: pendleConfig{expiresAt: tc.expiresAt, timeSource: TimeSourceSystem, multiplier: DefaultMultiplier, postExpiry: tc.postExpiry},
+				thresholdBps: tc.thresholdBps,
+			})
+			actual, err := f(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func Test_NewDeviationFunc_PendleImpliedRate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		ring := NewDecisionRing(1)
+		f, err := newDeviationFunc(t, map[string]any{
+			"type":         "pendle-implied-rate",
+			"expiresAt":    float64(4102444800), // 2100-01-01
+			"decimals":     float64(8),
+			"thresholdBps": float64(1),
+		})
+		require.NoError(t, err)
+		ctx := withDeviationRound(context.Background(), &deviationRound{decisions: ring})
+		deviates, err := f(ctx, 0, big.NewInt(50_000_000), big.NewInt(45_000_000))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+		decisions := ring.Decisions()
+		require.Len(t, decisions, 1)
+		assert.Equal(t, "pendle-implied-rate", decisions[0].Type)
+		assert.Contains(t, decisions[0].Intermediates, "diffBps")
+	})
+	t.Run("missing expiresAt", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle-implied-rate"})
+		require.EqualError(t, err, "missing or invalid 'expiresAt' field in deviation function definition")
+	})
+	t.Run("negative thresholdBps", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle-implied-rate", "expiresAt": float64(4102444800), "thresholdBps": float64(-1)})
+		require.EqualError(t, err, "invalid 'thresholdBps' field in deviation function definition: -1")
+	})
+	t.Run("non-numeric thresholdBps", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle-implied-rate", "expiresAt": float64(4102444800), "thresholdBps": "25"})
+		require.EqualError(t, err, "invalid 'thresholdBps' field in deviation function definition: 25")
+	})
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle-implied-rate", "expiresAt": float64(4102444800), "thresholdPPB": float64(1)})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,141 @@
+package median
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// DeviationFuncConstructor builds a deviation func from its definition, the
+// same map passed to [NewDeviationFunc] including its 'type' field.
+type DeviationFuncConstructor func(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error)
+
+type deviationFuncType struct {
+	ctor      DeviationFuncConstructor
+	schema    *jsonschema.Schema
+	rawSchema []byte
+}
+
+type deviationFuncRegistry struct {
+	mu    sync.RWMutex
+	types map[string]*deviationFuncType
+}
+
+var registry = &deviationFuncRegistry{types: map[string]*deviationFuncType{}}
+
+func init() {
+	for name, ctor := range map[string]DeviationFuncConstructor{
+		"pendle":              newPendleDeviationFunc,
+		"pendle-implied-rate": newPendleImpliedRateDeviationFunc,
+		"relative":            newRelativeDeviationFunc,
+		"absolute":            newAbsoluteDeviationFunc,
+		"volatilityAdaptive":  newVolatilityDeviationFunc,
+		"pegBand":             newPegBandDeviationFunc,
+		"asymmetric":          newAsymmetricDeviationFunc,
+		"hysteresis":          newHysteresisDeviationFunc,
+		"gasAware":            newGasAwareDeviationFunc,
+		"marketHours":         newMarketHoursDeviationFunc,
+		"expression":          newExpressionDeviationFunc,
+		"any": func(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+			return newCompositeDeviationFunc(lggr, opts, false)
+		},
+		"all": func(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+			return newCompositeDeviationFunc(lggr, opts, true)
+		},
+	} {
+		if err := RegisterDeviationFunc(name, ctor); err != nil {
+			panic(err)
+		}
+		rawSchema, err := builtinSchemas.ReadFile("schemas/" + name + ".json")
+		if err != nil {
+			panic(err)
+		}
+		if err := RegisterDeviationFuncSchema(name, rawSchema); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterDeviationFunc makes a deviation function type available to
+// [NewDeviationFunc] under name. It is meant to be called from init functions
+// of packages providing custom deviation logic. Registering a name twice is an error.
+func RegisterDeviationFunc(name string, ctor DeviationFuncConstructor) error {
+	if name == "" {
+		return errors.New("deviation function type name must not be empty")
+	}
+	if ctor == nil {
+		return fmt.Errorf("nil constructor for deviation function type %s", name)
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.types[name]; ok {
+		return fmt.Errorf("deviation function type %s is already registered", name)
+	}
+	registry.types[name] = &deviationFuncType{ctor: ctor}
+	return nil
+}
+
+// RegisterDeviationFuncSchema attaches a JSON Schema to the registered deviation
+// function type name. Definitions of that type are then strictly validated against
+// it by [NewDeviationFunc] and [ValidateDeviationDefinition]. Types without a
+// schema only have their 'type' field checked.
+func RegisterDeviationFuncSchema(name string, schema []byte) error {
+	compiled, err := compileDeviationSchema(name, schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema for deviation function type %s: %w", name, err)
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	t, ok := registry.types[name]
+	if !ok {
+		return fmt.Errorf("deviation function type %s is not registered", name)
+	}
+	if t.schema != nil {
+		return fmt.Errorf("deviation function type %s already has a schema", name)
+	}
+	t.schema = compiled
+	t.rawSchema = slices.Clone(schema)
+	return nil
+}
+
+// DeviationFuncSchema returns the JSON Schema registered for the deviation
+// function type name, or false if it has none.
+func DeviationFuncSchema(name string) ([]byte, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	t, ok := registry.types[name]
+	if !ok || t.schema == nil {
+		return nil, false
+	}
+	return slices.Clone(t.rawSchema), true
+}
+
+// RegisteredDeviationFuncTypes returns the sorted names of all registered deviation function types.
+func RegisteredDeviationFuncTypes() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	names := make([]string, 0, len(registry.types))
+	for name := range registry.types {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func lookupDeviationFunc(name string) (*deviationFuncType, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	t, ok := registry.types[name]
+	if !ok {
+		return nil, false
+	}
+	// Copy so the caller does not race with a concurrent RegisterDeviationFuncSchema
+	cp := *t
+	return &cp, true
+}
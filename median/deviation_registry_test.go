@@ -0,0 +1,68 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func unregisterDeviationFunc(t *testing.T, name string) {
+	t.Cleanup(func() {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		delete(registry.types, name)
+	})
+}
+
+func Test_RegisterDeviationFunc(t *testing.T) {
+	t.Run("built-in types are registered", func(t *testing.T) {
+		types := RegisteredDeviationFuncTypes()
+		for _, name := range []string{"absolute", "all", "any", "pendle", "relative"} {
+			assert.Contains(t, types, name)
+		}
+		assert.IsNonDecreasing(t, types)
+	})
+	t.Run("built-in name cannot be registered again", func(t *testing.T) {
+		err := RegisterDeviationFunc("pendle", newPendleDeviationFunc)
+		require.EqualError(t, err, "deviation function type pendle is already registered")
+	})
+	t.Run("empty name", func(t *testing.T) {
+		err := RegisterDeviationFunc("", newPendleDeviationFunc)
+		require.EqualError(t, err, "deviation function type name must not be empty")
+	})
+	t.Run("nil constructor", func(t *testing.T) {
+		err := RegisterDeviationFunc("custom-nil", nil)
+		require.EqualError(t, err, "nil constructor for deviation function type custom-nil")
+	})
+	t.Run("custom type is used by NewDeviationFunc", func(t *testing.T) {
+		var gotOpts map[string]any
+		unregisterDeviationFunc(t, "custom-always")
+		require.NoError(t, RegisterDeviationFunc("custom-always", func(_ logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+			gotOpts = opts
+			return constDeviationFunc(true, nil), nil
+		}))
+		require.EqualError(t, RegisterDeviationFunc("custom-always", newPendleDeviationFunc), "deviation function type custom-always is already registered")
+		assert.Contains(t, RegisteredDeviationFuncTypes(), "custom-always")
+
+		opts := map[string]any{"type": "custom-always", "foo": "bar"}
+		f, err := newDeviationFunc(t, opts)
+		require.NoError(t, err)
+		assert.Equal(t, opts, gotOpts)
+		deviates, err := f(nil, 1e7, big.NewInt(1), big.NewInt(1))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+
+		// Custom types can be nested in composites
+		_, err = newDeviationFunc(t, map[string]any{"type": "all", "functions": []any{opts}})
+		require.NoError(t, err)
+	})
+	t.Run("unknown type", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "custom-missing"})
+		require.EqualError(t, err, "unsupported function type in deviation function definition: custom-missing")
+	})
+}
@@ -0,0 +1,148 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// DefaultDecimals is the number of decimals assumed for feed values when a
+// deviation function definition does not specify 'decimals'.
+const DefaultDecimals = 18
+
+// ZeroPolicy decides whether to update when the old value is zero and a
+// relative deviation is therefore undefined.
+type ZeroPolicy string
+
+const (
+	// ZeroPolicyAlwaysUpdate updates whenever the new value is non-zero.
+	ZeroPolicyAlwaysUpdate ZeroPolicy = "alwaysUpdate"
+	// ZeroPolicyNeverUpdate never updates on deviation while the old value is zero.
+	ZeroPolicyNeverUpdate ZeroPolicy = "neverUpdate"
+	// ZeroPolicyAbsoluteFloor updates when the absolute new value reaches 'zeroFloor'.
+	ZeroPolicyAbsoluteFloor ZeroPolicy = "absoluteFloor"
+)
+
+func newRelativeDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	policyStr, ok := opts["zeroPolicy"].(string)
+	if !ok {
+		return nil, errors.New("missing or invalid 'zeroPolicy' field in deviation function definition")
+	}
+	policy := ZeroPolicy(policyStr)
+
+	var zeroFloor *big.Int
+	switch policy {
+	case ZeroPolicyAlwaysUpdate, ZeroPolicyNeverUpdate:
+	case ZeroPolicyAbsoluteFloor:
+		var err error
+		if zeroFloor, err = bigIntOption(opts, "zeroFloor"); err != nil {
+			return nil, err
+		}
+		if zeroFloor.Sign() < 0 {
+			return nil, fmt.Errorf("invalid 'zeroFloor' field in deviation function definition: %s", zeroFloor)
+		}
+	default:
+		return nil, fmt.Errorf("invalid 'zeroPolicy' field in deviation function definition: %s", policyStr)
+	}
+
+	rounding, err := parseRoundingOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeRelativeDeviationFunc(lggr, policy, zeroFloor, rounding), nil
+}
+
+// parseRoundingOptions reads the optional 'decimals' and 'roundToDecimals'
+// fields. It returns nil if no rounding was requested.
+func parseRoundingOptions(opts map[string]any) (*big.Int, error) {
+	decimals := uint64(DefaultDecimals)
+	if _, ok := opts["decimals"]; ok {
+		d, err := decimalsOption(opts, "decimals")
+		if err != nil {
+			return nil, err
+		}
+		decimals = d
+	}
+	if _, ok := opts["roundToDecimals"]; !ok {
+		return nil, nil
+	}
+	roundTo, err := decimalsOption(opts, "roundToDecimals")
+	if err != nil {
+		return nil, err
+	}
+	if roundTo > decimals {
+		return nil, fmt.Errorf("invalid 'roundToDecimals' field in deviation function definition: %d exceeds 'decimals' %d", roundTo, decimals)
+	}
+	return new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(decimals-roundTo), nil), nil
+}
+
+// roundToUnit rounds v half away from zero to a multiple of unit. A nil unit
+// returns v unchanged.
+func roundToUnit(v, unit *big.Int) *big.Int {
+	if unit == nil {
+		return v
+	}
+	abs := new(big.Int).Abs(v)
+	abs.Add(abs, new(big.Int).Rsh(unit, 1))
+	abs.Quo(abs, unit)
+	abs.Mul(abs, unit)
+	if v.Sign() < 0 {
+		abs.Neg(abs)
+	}
+	return abs
+}
+
+// relativeDeviates reports whether |newVal - oldVal| / |oldVal| >= thresholdPPB / 1e9.
+// oldVal must be non-zero.
+func relativeDeviates(thresholdPPB uint64, oldVal, newVal *big.Int) bool {
+	change := new(big.Rat).SetFrac(new(big.Int).Sub(newVal, oldVal), oldVal)
+	change.Abs(change)
+	threshold := new(big.Rat).SetFrac(new(big.Int).SetUint64(thresholdPPB), big.NewInt(1e9))
+	return change.Cmp(threshold) >= 0
+}
+
+// makeRelativeDeviationFunc makes a deviation func comparing the relative change
+// against thresholdPPB, with an explicit policy for a zero old value.
+//
+// If roundingUnit is non-nil, both values are rounded to a multiple of it first,
+// so that changes invisible at the feed's on-chain decimals never trigger an update.
+func makeRelativeDeviationFunc(lggr logger.Logger, policy ZeroPolicy, zeroFloor *big.Int, roundingUnit *big.Int) median.DeviationFunc {
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		oldRounded := roundToUnit(oldVal, roundingUnit)
+		newRounded := roundToUnit(newVal, roundingUnit)
+
+		var deviates bool
+		if oldRounded.Sign() == 0 {
+			switch policy {
+			case ZeroPolicyAlwaysUpdate:
+				deviates = newRounded.Sign() != 0
+			case ZeroPolicyNeverUpdate:
+				deviates = false
+			case ZeroPolicyAbsoluteFloor:
+				deviates = new(big.Int).Abs(newRounded).Cmp(zeroFloor) >= 0
+			default:
+				return false, fmt.Errorf("unsupported zero policy: %s", policy)
+			}
+		} else {
+			deviates = relativeDeviates(thresholdPPB, oldRounded, newRounded)
+		}
+
+		decision := newDeviationDecision("relative", thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["zeroPolicy"] = policy
+		decision.Intermediates["oldRounded"] = oldRounded.String()
+		decision.Intermediates["newRounded"] = newRounded.String()
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
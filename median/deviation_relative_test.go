@@ -0,0 +1,206 @@
+package median
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_NewDeviationFunc_Relative(t *testing.T) {
+	t.Run("missing zeroPolicy", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative"})
+		require.EqualError(t, err, "missing or invalid 'zeroPolicy' field in deviation function definition")
+	})
+	t.Run("unknown zeroPolicy", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "sometimes"})
+		require.EqualError(t, err, "invalid 'zeroPolicy' field in deviation function definition: sometimes")
+	})
+	t.Run("absoluteFloor requires zeroFloor", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "absoluteFloor"})
+		require.EqualError(t, err, "missing or invalid 'zeroFloor' field in deviation function definition")
+	})
+	t.Run("invalid zeroFloor", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "absoluteFloor", "zeroFloor": "-1"})
+		require.EqualError(t, err, "invalid 'zeroFloor' field in deviation function definition: -1")
+	})
+	t.Run("oversized zeroFloor", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "absoluteFloor", "zeroFloor": strings.Repeat("1", 101)})
+		require.EqualError(t, err, "invalid 'zeroFloor' field in deviation function definition: exceeds 256 bits")
+	})
+	t.Run("decimals exceed 256 bits", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate", "decimals": float64(255), "roundToDecimals": float64(0)})
+		require.EqualError(t, err, "invalid 'decimals' field in deviation function definition: 10^255 exceeds 256 bits")
+		require.Error(t, ValidateDeviationDefinition(map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate", "decimals": float64(78)}))
+		require.NoError(t, ValidateDeviationDefinition(map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate", "decimals": float64(77), "roundToDecimals": float64(77)}))
+	})
+	t.Run("roundToDecimals exceeds decimals", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate", "decimals": float64(8), "roundToDecimals": float64(9)})
+		require.EqualError(t, err, "invalid 'roundToDecimals' field in deviation function definition: 9 exceeds 'decimals' 8")
+	})
+	t.Run("fractional decimals", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate", "decimals": 8.5})
+		require.EqualError(t, err, "missing or invalid 'decimals' field in deviation function definition")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate"})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1000), big.NewInt(1010))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+		deviates, err = f(nil, 1e7, big.NewInt(1000), big.NewInt(1009))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+}
+
+func Test_RelativeDeviationFunc(t *testing.T) {
+	tcs := []struct {
+		name string
+
+		policy       ZeroPolicy
+		zeroFloor    *big.Int
+		roundingUnit *big.Int
+		thresholdPPB uint64
+		oldVal       *big.Int
+		newVal       *big.Int
+
+		err      string
+		expected bool
+	}{
+		{
+			name:   "nil oldVal errors",
+			policy: ZeroPolicyAlwaysUpdate,
+			oldVal: nil,
+			newVal: big.NewInt(2),
+			err:    "oldVal and newVal must be non-nil",
+		},
+		{
+			name:   "nil newVal errors",
+			policy: ZeroPolicyAlwaysUpdate,
+			oldVal: big.NewInt(1),
+			newVal: nil,
+			err:    "oldVal and newVal must be non-nil",
+		},
+		{
+			name:         "exactly at threshold - SHOULD UPDATE",
+			policy:       ZeroPolicyNeverUpdate,
+			thresholdPPB: 5e6,
+			oldVal:       big.NewInt(2000),
+			newVal:       big.NewInt(1990),
+			expected:     true,
+		},
+		{
+			name:         "below threshold - SHOULD NOT UPDATE",
+			policy:       ZeroPolicyNeverUpdate,
+			thresholdPPB: 5e6,
+			oldVal:       big.NewInt(2000),
+			newVal:       big.NewInt(2009),
+			expected:     false,
+		},
+		{
+			name:         "negative old value uses magnitude - SHOULD UPDATE",
+			policy:       ZeroPolicyNeverUpdate,
+			thresholdPPB: 1e8,
+			oldVal:       big.NewInt(-100),
+			newVal:       big.NewInt(-110),
+			expected:     true,
+		},
+		{
+			name:     "alwaysUpdate, zero to non-zero - SHOULD UPDATE",
+			policy:   ZeroPolicyAlwaysUpdate,
+			oldVal:   big.NewInt(0),
+			newVal:   big.NewInt(1),
+			expected: true,
+		},
+		{
+			name:     "alwaysUpdate, zero to zero - SHOULD NOT UPDATE",
+			policy:   ZeroPolicyAlwaysUpdate,
+			oldVal:   big.NewInt(0),
+			newVal:   big.NewInt(0),
+			expected: false,
+		},
+		{
+			name:     "neverUpdate, zero to non-zero - SHOULD NOT UPDATE",
+			policy:   ZeroPolicyNeverUpdate,
+			oldVal:   big.NewInt(0),
+			newVal:   big.NewInt(1e18),
+			expected: false,
+		},
+		{
+			name:      "absoluteFloor, below floor - SHOULD NOT UPDATE",
+			policy:    ZeroPolicyAbsoluteFloor,
+			zeroFloor: big.NewInt(100),
+			oldVal:    big.NewInt(0),
+			newVal:    big.NewInt(-99),
+			expected:  false,
+		},
+		{
+			name:      "absoluteFloor, at floor - SHOULD UPDATE",
+			policy:    ZeroPolicyAbsoluteFloor,
+			zeroFloor: big.NewInt(100),
+			oldVal:    big.NewInt(0),
+			newVal:    big.NewInt(-100),
+			expected:  true,
+		},
+		{
+			name:         "rounding hides sub-unit change - SHOULD NOT UPDATE",
+			policy:       ZeroPolicyNeverUpdate,
+			roundingUnit: big.NewInt(100),
+			thresholdPPB: 1e6,
+			oldVal:       big.NewInt(100_049),
+			newVal:       big.NewInt(99_950),
+			expected:     false,
+		},
+		{
+			name:         "rounding rounds half away from zero - SHOULD UPDATE",
+			policy:       ZeroPolicyNeverUpdate,
+			roundingUnit: big.NewInt(100),
+			thresholdPPB: 1e6,
+			oldVal:       big.NewInt(100_049),
+			newVal:       big.NewInt(100_150),
+			expected:     true,
+		},
+		{
+			name:         "rounding to zero applies zero policy - SHOULD UPDATE",
+			policy:       ZeroPolicyAlwaysUpdate,
+			roundingUnit: big.NewInt(100),
+			thresholdPPB: 1e6,
+			oldVal:       big.NewInt(49),
+			newVal:       big.NewInt(50),
+			expected:     true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var oldValS, newValS string
+			if tc.oldVal != nil {
+				oldValS = tc.oldVal.String()
+			}
+			if tc.newVal != nil {
+				newValS = tc.newVal.String()
+			}
+
+			actual, err := makeRelativeDeviationFunc(logger.Test(t), tc.policy, tc.zeroFloor, tc.roundingUnit)(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			}
+
+			// Did not mutate passed args
+			if tc.oldVal != nil {
+				assert.Equal(t, oldValS, tc.oldVal.String())
+			}
+			if tc.newVal != nil {
+				assert.Equal(t, newValS, tc.newVal.String())
+			}
+		})
+	}
+}
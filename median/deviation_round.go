@@ -0,0 +1,338 @@
+package median
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// TimeSource selects where a deviation func reads the current time from.
+type TimeSource string
+
+const (
+	// TimeSourceSystem reads the local system clock. Oracles may disagree near a threshold.
+	TimeSourceSystem TimeSource = "system"
+	// TimeSourceObservationTimestamp uses the aggregated observation timestamp of the
+	// report being evaluated, which all oracles agree on. Accepting reports needs
+	// a report codec able to read it back, see [checkReportCodecSupport].
+	TimeSourceObservationTimestamp TimeSource = "observationTimestamp"
+	// TimeSourceTransmissionTimestamp uses the timestamp of the latest on-chain
+	// transmission, falling back to the observation timestamp if nothing has been
+	// transmitted yet.
+	TimeSourceTransmissionTimestamp TimeSource = "transmissionTimestamp"
+)
+
+func parseTimeSource(opts map[string]any) (TimeSource, error) {
+	v, ok := opts["clock"]
+	if !ok {
+		return TimeSourceSystem, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", errors.New("invalid 'clock' field in deviation function definition")
+	}
+	switch source := TimeSource(s); source {
+	case TimeSourceSystem, TimeSourceObservationTimestamp, TimeSourceTransmissionTimestamp:
+		return source, nil
+	default:
+		return "", fmt.Errorf("invalid 'clock' field in deviation function definition: %s", s)
+	}
+}
+
+// deviationNow returns the time a deviation func should treat as now. clock is
+// only consulted for TimeSourceSystem, unless the round in ctx overrides it;
+// other sources read the round in ctx.
+func deviationNow(ctx context.Context, clock Clock, source TimeSource) (time.Time, error) {
+	round := deviationRoundFromContext(ctx)
+	if source == TimeSourceSystem {
+		if round != nil && round.clock != nil {
+			return round.clock.Now(), nil
+		}
+		return clock.Now(), nil
+	}
+	if round == nil {
+		return time.Time{}, fmt.Errorf("clock %q requires round data, which is only available inside the reporting plugin", source)
+	}
+	return round.now(source)
+}
+
+// deviationRound carries data about the OCR round being evaluated that
+// [median.DeviationFunc] does not receive as arguments. The reporting plugin
+// wrapper attaches it to the context passed down to the deviation func.
+type deviationRound struct {
+	configDigest ocrtypes.ConfigDigest
+	stage        deviationStage
+
+	// observationTimestamp is the aggregated timestamp of the report, zero if unknown.
+	observationTimestamp time.Time
+	// juelsPerFeeCoin and gasPriceSubunits are the aggregated fee observations of the report, nil if unknown.
+	juelsPerFeeCoin  *big.Int
+	gasPriceSubunits *big.Int
+
+	// decisions receives the decisions of deviation funcs evaluated in this round, if set.
+	decisions DecisionSink
+	// clock replaces the system clock of deviation funcs if set, to replay historical data.
+	clock Clock
+
+	mu                 sync.Mutex
+	latestTransmission *transmissionDetails
+}
+
+// deviationStage is the reporting plugin step evaluating the deviation func.
+type deviationStage int
+
+const (
+	// deviationStageReport decides whether to build a report from fresh observations.
+	deviationStageReport deviationStage = iota
+	// deviationStageAccept decides whether to accept a report that has already been built.
+	deviationStageAccept
+)
+
+func (s deviationStage) String() string {
+	switch s {
+	case deviationStageReport:
+		return "report"
+	case deviationStageAccept:
+		return "accept"
+	default:
+		return fmt.Sprintf("deviationStage(%d)", int(s))
+	}
+}
+
+type transmissionDetails struct {
+	configDigest ocrtypes.ConfigDigest
+	epoch        uint32
+	round        uint8
+	latestAnswer *big.Int
+	timestamp    time.Time
+}
+
+func (r *deviationRound) setLatestTransmission(details transmissionDetails) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latestTransmission = &details
+}
+
+func (r *deviationRound) getLatestTransmission() *transmissionDetails {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latestTransmission
+}
+
+func (r *deviationRound) now(source TimeSource) (time.Time, error) {
+	switch source {
+	case TimeSourceTransmissionTimestamp:
+		if t := r.getLatestTransmission(); t != nil && !t.timestamp.IsZero() {
+			return t.timestamp, nil
+		}
+		// Nothing transmitted yet, so use the next best value all oracles agree on.
+		fallthrough
+	case TimeSourceObservationTimestamp:
+		if r.observationTimestamp.IsZero() {
+			return time.Time{}, errors.New("no observation timestamp available for this round")
+		}
+		return r.observationTimestamp, nil
+	case TimeSourceSystem:
+		if r.clock != nil {
+			return r.clock.Now(), nil
+		}
+		return time.Now(), nil
+	default:
+		return time.Time{}, fmt.Errorf("unsupported time source: %s", source)
+	}
+}
+
+type deviationRoundKey struct{}
+
+func withDeviationRound(ctx context.Context, round *deviationRound) context.Context {
+	return context.WithValue(ctx, deviationRoundKey{}, round)
+}
+
+func deviationRoundFromContext(ctx context.Context) *deviationRound {
+	if ctx == nil {
+		return nil
+	}
+	round, _ := ctx.Value(deviationRoundKey{}).(*deviationRound)
+	return round
+}
+
+// deviationContract records the latest transmission details into the round
+// data of the calling context, so deviation funcs can use them.
+type deviationContract struct {
+	median.MedianContract
+}
+
+func (c *deviationContract) LatestTransmissionDetails(ctx context.Context) (configDigest ocrtypes.ConfigDigest, epoch uint32, round uint8, latestAnswer *big.Int, latestTimestamp time.Time, err error) {
+	configDigest, epoch, round, latestAnswer, latestTimestamp, err = c.MedianContract.LatestTransmissionDetails(ctx)
+	if r := deviationRoundFromContext(ctx); r != nil && err == nil {
+		r.setLatestTransmission(transmissionDetails{configDigest, epoch, round, latestAnswer, latestTimestamp})
+	}
+	return
+}
+
+// reportTimestampReader is implemented by report codecs able to read the
+// aggregated observation timestamp back out of a report.
+type reportTimestampReader interface {
+	TimestampFromReport(ctx context.Context, report ocrtypes.Report) (uint32, error)
+}
+
+// reportFeeReader is implemented by report codecs able to read the aggregated
+// fee observations back out of a report.
+type reportFeeReader interface {
+	FeesFromReport(ctx context.Context, report ocrtypes.Report) (juelsPerFeeCoin, gasPriceSubunits *big.Int, err error)
+}
+
+// checkReportCodecSupport returns an error if the deviation func defined by opts
+// needs round data that the accept stage reads back from reports, and codec cannot
+// read it. Such a func would fail on every report, so none would be accepted.
+func checkReportCodecSupport(opts map[string]any, codec median.ReportCodec) error {
+	return checkReportCodecNode(opts, codec, "")
+}
+
+func checkReportCodecNode(opts map[string]any, codec median.ReportCodec, path string) error {
+	switch source, _ := opts["clock"].(string); TimeSource(source) {
+	case TimeSourceObservationTimestamp, TimeSourceTransmissionTimestamp:
+		if _, ok := codec.(reportTimestampReader); !ok {
+			return fmt.Errorf("clock %q at #%s/clock needs the report timestamp, which the report codec cannot read", source, path)
+		}
+	}
+	if opts["type"] == "gasAware" {
+		if _, ok := codec.(reportFeeReader); !ok {
+			return fmt.Errorf("gasAware at #%s needs the report fees, which the report codec cannot read", path)
+		}
+	}
+	for _, child := range childDefinitions(opts) {
+		if err := checkReportCodecNode(child.opts, codec, path+child.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deviationReportingPluginFactory wraps the reporting plugins it creates so that
+// deviation funcs receive round data through their context.
+type deviationReportingPluginFactory struct {
+	ocrtypes.ReportingPluginFactory
+	reportCodec median.ReportCodec
+	decisions   DecisionSink
+}
+
+func (f *deviationReportingPluginFactory) NewReportingPlugin(ctx context.Context, config ocrtypes.ReportingPluginConfig) (ocrtypes.ReportingPlugin, ocrtypes.ReportingPluginInfo, error) {
+	plugin, info, err := f.ReportingPluginFactory.NewReportingPlugin(ctx, config)
+	if err != nil {
+		return nil, info, err
+	}
+	return &deviationReportingPlugin{ReportingPlugin: plugin, configDigest: config.ConfigDigest, reportCodec: f.reportCodec, decisions: f.decisions}, info, nil
+}
+
+type deviationReportingPlugin struct {
+	ocrtypes.ReportingPlugin
+	configDigest ocrtypes.ConfigDigest
+	reportCodec  median.ReportCodec
+	decisions    DecisionSink
+}
+
+func (p *deviationReportingPlugin) Report(ctx context.Context, repts ocrtypes.ReportTimestamp, query ocrtypes.Query, aos []ocrtypes.AttributedObservation) (bool, ocrtypes.Report, error) {
+	round := &deviationRound{configDigest: p.configDigest, stage: deviationStageReport, decisions: p.decisions}
+	if paos := parseAttributedObservations(aos); len(paos) > 0 {
+		var timestamps *timestampPolicy
+		if rc, ok := p.reportCodec.(*reportCodec); ok {
+			// Derive the values from the observations the codec builds the report from, so both stages see the same ones.
+			timestamps = rc.timestamps
+			paos, _, _ = timestamps.dropDrifted(paos)
+		}
+		round.observationTimestamp = time.Unix(int64(reportTimestamp(paos, timestamps)), 0)
+		round.juelsPerFeeCoin = medianOf(paos, func(pao median.ParsedAttributedObservation) *big.Int { return pao.JuelsPerFeeCoin })
+		round.gasPriceSubunits = medianOf(paos, func(pao median.ParsedAttributedObservation) *big.Int { return pao.GasPriceSubunits })
+	}
+	return p.ReportingPlugin.Report(withDeviationRound(ctx, round), repts, query, aos)
+}
+
+func (p *deviationReportingPlugin) ShouldAcceptFinalizedReport(ctx context.Context, repts ocrtypes.ReportTimestamp, report ocrtypes.Report) (bool, error) {
+	round := &deviationRound{configDigest: p.configDigest, stage: deviationStageAccept, decisions: p.decisions}
+	if tr, ok := p.reportCodec.(reportTimestampReader); ok {
+		// A report that cannot be decoded is rejected by the wrapped plugin anyway.
+		if ts, err := tr.TimestampFromReport(ctx, report); err == nil {
+			round.observationTimestamp = time.Unix(int64(ts), 0)
+		}
+	}
+	if fr, ok := p.reportCodec.(reportFeeReader); ok {
+		if juels, gas, err := fr.FeesFromReport(ctx, report); err == nil {
+			round.juelsPerFeeCoin, round.gasPriceSubunits = juels, gas
+		}
+	}
+	return p.ReportingPlugin.ShouldAcceptFinalizedReport(withDeviationRound(ctx, round), repts, report)
+}
+
+// reportTimestamp picks the report timestamp the same way aggregate does with
+// the timestamp policy timestamps, the upper median if nil.
+func reportTimestamp(paos []median.ParsedAttributedObservation, timestamps *timestampPolicy) uint32 {
+	sorted := slices.Clone(paos)
+	slices.SortFunc(sorted, func(a, b median.ParsedAttributedObservation) int {
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	})
+	return timestamps.pick(sorted)
+}
+
+// medianOf picks the value returned by get the same way aggregate does.
+func medianOf(paos []median.ParsedAttributedObservation, get func(median.ParsedAttributedObservation) *big.Int) *big.Int {
+	values := make([]*big.Int, len(paos))
+	for i, pao := range paos {
+		values[i] = get(pao)
+	}
+	slices.SortFunc(values, (*big.Int).Cmp)
+	return values[len(values)/2]
+}
+
+// parseAttributedObservations mirrors the parsing done by the libocr median
+// plugin, dropping the same invalid observations, so that values derived here
+// match the ones the plugin builds its report from.
+func parseAttributedObservations(aos []ocrtypes.AttributedObservation) []median.ParsedAttributedObservation {
+	paos := make([]median.ParsedAttributedObservation, 0, len(aos))
+	for _, ao := range aos {
+		pao, err := parseAttributedObservation(ao.Observation, ao.Observer)
+		if err != nil {
+			continue
+		}
+		paos = append(paos, pao)
+	}
+	return paos
+}
+
+func parseAttributedObservation(observation ocrtypes.Observation, observer commontypes.OracleID) (median.ParsedAttributedObservation, error) {
+	var observationProto median.NumericalMedianObservationProto
+	if err := proto.Unmarshal(observation, &observationProto); err != nil {
+		return median.ParsedAttributedObservation{}, err
+	}
+	value, err := median.DecodeValue(observationProto.Value)
+	if err != nil {
+		return median.ParsedAttributedObservation{}, err
+	}
+	juelsPerFeeCoin, err := median.DecodeValue(observationProto.JuelsPerFeeCoin)
+	if err != nil {
+		return median.ParsedAttributedObservation{}, err
+	}
+	gasPriceSubunits := new(big.Int)
+	if len(observationProto.GasPriceSubunits) > 0 {
+		if gasPriceSubunits, err = median.DecodeValue(observationProto.GasPriceSubunits); err != nil {
+			return median.ParsedAttributedObservation{}, err
+		}
+	}
+	return median.ParsedAttributedObservation{
+		Timestamp:        observationProto.Timestamp,
+		Value:            value,
+		JuelsPerFeeCoin:  juelsPerFeeCoin,
+		GasPriceSubunits: gasPriceSubunits,
+		Observer:         observer,
+	}, nil
+}
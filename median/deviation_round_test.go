@@ -0,0 +1,238 @@
+package median
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+	"github.com/smartcontractkit/chainlink-common/pkg/utils/tests"
+)
+
+func Test_NewDeviationFunc_Clock(t *testing.T) {
+	t.Run("invalid clock", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle", "expiresAt": float64(1), "clock": "sundial"})
+		require.EqualError(t, err, "invalid 'clock' field in deviation function definition: sundial")
+	})
+	t.Run("deterministic clock requires round data", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "pendle", "expiresAt": float64(1), "clock": "observationTimestamp"})
+		require.NoError(t, err)
+		_, err = f(tests.Context(t), 1e7, big.NewInt(1), big.NewInt(2))
+		require.EqualError(t, err, `clock "observationTimestamp" requires round data, which is only available inside the reporting plugin`)
+	})
+}
+
+// legacyReportCodec only has the methods of [median.ReportCodec], like the codecs of providers without a generic codec.
+type legacyReportCodec struct {
+	median.ReportCodec
+}
+
+func Test_checkReportCodecSupport(t *testing.T) {
+	pendle := func(clock string) map[string]any {
+		return map[string]any{"type": "pendle", "expiresAt": float64(1), "clock": clock}
+	}
+	tcs := []struct {
+		name  string
+		opts  map[string]any
+		codec median.ReportCodec
+		err   string
+	}{
+		{name: "system clock without a codec", opts: pendle("system"), codec: legacyReportCodec{}},
+		{name: "no clock without a codec", opts: map[string]any{"type": "relative"}, codec: legacyReportCodec{}},
+		{name: "observation timestamp with a codec", opts: pendle("observationTimestamp"), codec: &reportCodec{}},
+		{
+			name:  "observation timestamp without a codec",
+			opts:  pendle("observationTimestamp"),
+			codec: legacyReportCodec{},
+			err:   `clock "observationTimestamp" at #/clock needs the report timestamp, which the report codec cannot read`,
+		},
+		{
+			name:  "nested transmission timestamp without a codec",
+			opts:  map[string]any{"type": "any", "functions": []any{map[string]any{"type": "relative"}, pendle("transmissionTimestamp")}},
+			codec: legacyReportCodec{},
+			err:   `clock "transmissionTimestamp" at #/functions/1/clock needs the report timestamp, which the report codec cannot read`,
+		},
+		{name: "gasAware with a codec", opts: map[string]any{"type": "gasAware"}, codec: &reportCodec{}},
+		{
+			name:  "nested gasAware without a codec",
+			opts:  map[string]any{"type": "hysteresis", "function": map[string]any{"type": "gasAware"}},
+			codec: legacyReportCodec{},
+			err:   "gasAware at #/function needs the report fees, which the report codec cannot read",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkReportCodecSupport(tc.opts, tc.codec)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_PendleDeviationFunc_TimeSource(t *testing.T) {
+	clock := frozenTimeClock{}
+	expiresAt := float64(clock.Now().Unix()) + 13857541.0
+	oldVal := big.NewInt(0.187152977881070687 * 1e18)
+	newVal := big.NewInt(0.164498448931278907 * 1e18)
+
+	// At the frozen clock time this deviates (test 0 in Test_PendleDeviationFunc), but
+	// it must not once the round time is close enough to expiry.
+	nearExpiry := clock.Now().Add(13000000 * time.Second)
+
+	t.Run("observation timestamp overrides the clock", func(t *testing.T) {
+		f := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{expiresAt: expiresAt, timeSource: TimeSourceObservationTimestamp, multiplier: DefaultMultiplier})
+		ctx := withDeviationRound(tests.Context(t), &deviationRound{observationTimestamp: nearExpiry})
+		deviates, err := f(ctx, 1e7, oldVal, newVal)
+		require.NoError(t, err)
+		assert.False(t, deviates)
+
+		ctx = withDeviationRound(tests.Context(t), &deviationRound{observationTimestamp: clock.Now()})
+		deviates, err = f(ctx, 1e7, oldVal, newVal)
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+	t.Run("transmission timestamp overrides the observation timestamp", func(t *testing.T) {
+		f := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{expiresAt: expiresAt, timeSource: TimeSourceTransmissionTimestamp, multiplier: DefaultMultiplier})
+		round := &deviationRound{observationTimestamp: clock.Now()}
+		round.setLatestTransmission(transmissionDetails{timestamp: nearExpiry})
+		deviates, err := f(withDeviationRound(tests.Context(t), round), 1e7, oldVal, newVal)
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+	t.Run("transmission timestamp falls back to observation timestamp before first transmission", func(t *testing.T) {
+		f := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{expiresAt: expiresAt, timeSource: TimeSourceTransmissionTimestamp, multiplier: DefaultMultiplier})
+		round := &deviationRound{observationTimestamp: nearExpiry}
+		round.setLatestTransmission(transmissionDetails{})
+		deviates, err := f(withDeviationRound(tests.Context(t), round), 1e7, oldVal, newVal)
+		require.NoError(t, err)
+		assert.False(t, deviates)
+	})
+	t.Run("missing observation timestamp errors", func(t *testing.T) {
+		f := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{expiresAt: expiresAt, timeSource: TimeSourceTransmissionTimestamp, multiplier: DefaultMultiplier})
+		_, err := f(withDeviationRound(tests.Context(t), &deviationRound{}), 1e7, oldVal, newVal)
+		require.EqualError(t, err, "no observation timestamp available for this round")
+	})
+}
+
+func Test_DeviationReportingPlugin(t *testing.T) {
+	digest := ocrtypes.ConfigDigest{1, 2, 3}
+
+	observation := func(t *testing.T, ts uint32, value int64) ocrtypes.Observation {
+		encoded, err := median.EncodeValue(big.NewInt(value))
+		require.NoError(t, err)
+		b, err := proto.Marshal(&median.NumericalMedianObservationProto{Timestamp: ts, Value: encoded, JuelsPerFeeCoin: encoded})
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("Report attaches round data and records transmission details", func(t *testing.T) {
+		contract := &deviationContract{MedianContract: &fakeMedianContract{timestamp: time.Unix(1000, 0), epoch: 7}}
+		inner := &fakeReportingPlugin{contract: contract}
+		p := &deviationReportingPlugin{ReportingPlugin: inner, configDigest: digest}
+
+		aos := []ocrtypes.AttributedObservation{
+			{Observation: observation(t, 130, 1), Observer: commontypes.OracleID(0)},
+			{Observation: []byte("garbage"), Observer: commontypes.OracleID(1)},
+			{Observation: observation(t, 110, 2), Observer: commontypes.OracleID(2)},
+			{Observation: observation(t, 120, 3), Observer: commontypes.OracleID(3)},
+		}
+		_, _, err := p.Report(tests.Context(t), ocrtypes.ReportTimestamp{}, nil, aos)
+		require.NoError(t, err)
+
+		require.NotNil(t, inner.round)
+		assert.Equal(t, digest, inner.round.configDigest)
+		assert.Equal(t, time.Unix(120, 0), inner.round.observationTimestamp)
+		assert.Equal(t, "2", inner.round.juelsPerFeeCoin.String())
+		assert.Equal(t, "0", inner.round.gasPriceSubunits.String())
+		transmission := inner.round.getLatestTransmission()
+		require.NotNil(t, transmission)
+		assert.Equal(t, time.Unix(1000, 0), transmission.timestamp)
+		assert.Equal(t, uint32(7), transmission.epoch)
+	})
+
+	t.Run("Report derives round data like the codec builds the report", func(t *testing.T) {
+		inner := &fakeReportingPlugin{}
+		p := &deviationReportingPlugin{
+			ReportingPlugin: inner,
+			configDigest:    digest,
+			reportCodec:     &reportCodec{timestamps: &timestampPolicy{method: TimestampMax, maxDriftSeconds: 30}},
+		}
+
+		// The observation at 3600 drifts and is dropped, so its fees are left out too
+		aos := []ocrtypes.AttributedObservation{
+			{Observation: observation(t, 130, 1), Observer: commontypes.OracleID(0)},
+			{Observation: observation(t, 3600, 0), Observer: commontypes.OracleID(1)},
+			{Observation: observation(t, 110, 2), Observer: commontypes.OracleID(2)},
+			{Observation: observation(t, 120, 3), Observer: commontypes.OracleID(3)},
+			{Observation: observation(t, 125, 4), Observer: commontypes.OracleID(4)},
+		}
+		_, _, err := p.Report(tests.Context(t), ocrtypes.ReportTimestamp{}, nil, aos)
+		require.NoError(t, err)
+
+		require.NotNil(t, inner.round)
+		assert.Equal(t, time.Unix(130, 0), inner.round.observationTimestamp)
+		assert.Equal(t, "3", inner.round.juelsPerFeeCoin.String())
+	})
+
+	t.Run("ShouldAcceptFinalizedReport reads the timestamp and fees from the report", func(t *testing.T) {
+		report := []byte{1, 2, 3}
+		inner := &fakeReportingPlugin{}
+		p := &deviationReportingPlugin{
+			ReportingPlugin: inner,
+			configDigest:    digest,
+			reportCodec:     &reportCodec{codec: &testCodec{t: t, expected: report, result: aggregatedAttributedObservation{Timestamp: 99, JuelsPerFeeCoin: big.NewInt(5), GasPriceSubunit: big.NewInt(6)}}},
+		}
+
+		_, err := p.ShouldAcceptFinalizedReport(tests.Context(t), ocrtypes.ReportTimestamp{}, report)
+		require.NoError(t, err)
+		require.NotNil(t, inner.round)
+		assert.Equal(t, time.Unix(99, 0), inner.round.observationTimestamp)
+		assert.Equal(t, "5", inner.round.juelsPerFeeCoin.String())
+		assert.Equal(t, "6", inner.round.gasPriceSubunits.String())
+	})
+}
+
+type fakeReportingPlugin struct {
+	ocrtypes.ReportingPlugin
+	contract median.MedianContract
+	round    *deviationRound
+}
+
+func (f *fakeReportingPlugin) Report(ctx context.Context, _ ocrtypes.ReportTimestamp, _ ocrtypes.Query, _ []ocrtypes.AttributedObservation) (bool, ocrtypes.Report, error) {
+	f.round = deviationRoundFromContext(ctx)
+	if f.contract != nil {
+		if _, _, _, _, _, err := f.contract.LatestTransmissionDetails(ctx); err != nil {
+			return false, nil, err
+		}
+	}
+	return false, nil, nil
+}
+
+func (f *fakeReportingPlugin) ShouldAcceptFinalizedReport(ctx context.Context, _ ocrtypes.ReportTimestamp, _ ocrtypes.Report) (bool, error) {
+	f.round = deviationRoundFromContext(ctx)
+	return true, nil
+}
+
+type fakeMedianContract struct {
+	timestamp time.Time
+	epoch     uint32
+}
+
+func (f *fakeMedianContract) LatestTransmissionDetails(context.Context) (ocrtypes.ConfigDigest, uint32, uint8, *big.Int, time.Time, error) {
+	return ocrtypes.ConfigDigest{}, f.epoch, 0, new(big.Int), f.timestamp, nil
+}
+
+func (f *fakeMedianContract) LatestRoundRequested(context.Context, time.Duration) (ocrtypes.ConfigDigest, uint32, uint8, error) {
+	return ocrtypes.ConfigDigest{}, 0, 0, nil
+}
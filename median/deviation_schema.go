@@ -0,0 +1,267 @@
+package median
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+//go:embed schemas/*.json
+var builtinSchemas embed.FS
+
+// mustCompileBuiltinSchema compiles the embedded schema schemas/<name>.json, panicking if it is broken.
+func mustCompileBuiltinSchema(name string) *jsonschema.Schema {
+	raw, err := builtinSchemas.ReadFile("schemas/" + name + ".json")
+	if err != nil {
+		panic(err)
+	}
+	schema, err := compileDeviationSchema(name, raw)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func compileDeviationSchema(name string, schema []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	url := name + ".json"
+	if err := compiler.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(url)
+}
+
+// DefinitionError is a single problem found in a deviation function definition.
+type DefinitionError struct {
+	// Path is a JSON pointer to the offending value, empty for the definition itself.
+	Path    string
+	Message string
+}
+
+func (e DefinitionError) Error() string {
+	return "#" + e.Path + ": " + e.Message
+}
+
+// DefinitionErrors lists every problem found in a deviation function definition.
+type DefinitionErrors []DefinitionError
+
+func (e DefinitionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "invalid deviation function definition: " + strings.Join(msgs, "; ")
+}
+
+// ValidateDeviationDefinition checks opts the same way [NewDeviationFunc] does
+// without keeping the result, so a definition can be checked before a job is
+// created. Nested definitions are validated too. Schema violations are all
+// reported, as [DefinitionErrors] with the JSON path of each problem.
+//
+// opts may be the whole configuration passed to [Plugin.NewMedianFactory], so
+// its 'aggregator', 'outlierFilter', 'timestampPolicy' and 'reportVersion'
+// fields are checked on their own and the rest as the deviation function
+// definition. Without a deviation function definition, only they are checked.
+func ValidateDeviationDefinition(opts map[string]any) error {
+	hasFactorySettings := false
+	for _, key := range factorySettingKeys {
+		if _, ok := opts[key]; ok {
+			hasFactorySettings = true
+		}
+	}
+	opts, errs := validateFactorySettings(opts)
+	if len(opts) > 0 || !hasFactorySettings {
+		errs = append(errs, validateDefinitionTree(opts, "", 1)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	// Some constraints, like bit lengths, are only checked when constructing.
+	if _, err := NewDeviationFunc(logger.Nop(), opts); err != nil {
+		return DefinitionErrors{{Message: err.Error()}}
+	}
+	return nil
+}
+
+// factorySettingKeys are the fields of the factory configuration that are not part of the deviation function definition.
+var factorySettingKeys = []string{aggregatorKey, outlierFilterKey, timestampPolicyKey, reportVersionKey}
+
+// factorySettingSchemas are the schemas of the factory settings that have one.
+var factorySettingSchemas = map[string]*jsonschema.Schema{
+	outlierFilterKey: mustCompileBuiltinSchema(outlierFilterKey),
+}
+
+// validateFactorySettings checks the factory-level settings of cfg the way
+// [Plugin.NewMedianFactory] reads them, and returns cfg without them.
+func validateFactorySettings(cfg map[string]any) (map[string]any, DefinitionErrors) {
+	var errs DefinitionErrors
+	check := func(key string, split func(map[string]any) (map[string]any, error)) {
+		v, ok := cfg[key]
+		if !ok {
+			return
+		}
+		if schema := factorySettingSchemas[key]; schema != nil {
+			if schemaErrs := validateAgainst(schema, v, "/"+key); len(schemaErrs) > 0 {
+				errs = append(errs, schemaErrs...)
+				cfg = maps.Clone(cfg)
+				delete(cfg, key)
+				return
+			}
+		}
+		rest, err := split(cfg)
+		if err != nil {
+			errs = append(errs, DefinitionError{Path: "/" + key, Message: err.Error()})
+			rest = maps.Clone(cfg)
+			delete(rest, key)
+		}
+		cfg = rest
+	}
+	check(aggregatorKey, func(cfg map[string]any) (map[string]any, error) {
+		_, rest, err := splitAggregatorConfig(cfg)
+		return rest, err
+	})
+	var outliers *outlierFilter
+	check(outlierFilterKey, func(cfg map[string]any) (map[string]any, error) {
+		f, rest, err := splitOutlierFilterConfig(cfg)
+		outliers = f
+		return rest, err
+	})
+	check(timestampPolicyKey, func(cfg map[string]any) (map[string]any, error) {
+		_, rest, err := splitTimestampPolicyConfig(cfg)
+		return rest, err
+	})
+	version := reportVersion1
+	check(reportVersionKey, func(cfg map[string]any) (map[string]any, error) {
+		v, rest, err := splitReportVersionConfig(cfg)
+		version = max(v, version)
+		return rest, err
+	})
+	if err := checkOutlierFilterVersion(outliers, version); err != nil {
+		errs = append(errs, DefinitionError{Path: "/" + outlierFilterKey, Message: err.Error()})
+	}
+	return cfg, errs
+}
+
+func validateDefinitionTree(opts map[string]any, path string, depth int) DefinitionErrors {
+	if depth > MaxCompositeDepth {
+		return DefinitionErrors{{Path: path, Message: fmt.Sprintf("nesting exceeds %d levels", MaxCompositeDepth)}}
+	}
+	typeVal, ok := opts["type"].(string)
+	if !ok {
+		return DefinitionErrors{{Path: path + "/type", Message: "missing or invalid 'type' field"}}
+	}
+	t, ok := lookupDeviationFunc(typeVal)
+	if !ok {
+		return DefinitionErrors{{Path: path + "/type", Message: "unsupported function type: " + typeVal}}
+	}
+
+	errs := schemaErrors(t, opts, path)
+	for _, child := range childDefinitions(opts) {
+		errs = append(errs, validateDefinitionTree(child.opts, path+child.path, depth+1)...)
+	}
+	return errs
+}
+
+type childDefinition struct {
+	// path relative to the parent definition
+	path string
+	opts map[string]any
+}
+
+// childDefinitions returns the nested definitions of opts. By convention they
+// are kept in a 'function' object or a 'functions' array.
+func childDefinitions(opts map[string]any) []childDefinition {
+	var children []childDefinition
+	if child, ok := opts["function"].(map[string]any); ok {
+		children = append(children, childDefinition{path: "/function", opts: child})
+	}
+	if functions, ok := opts["functions"].([]any); ok {
+		for i, f := range functions {
+			if child, ok := f.(map[string]any); ok {
+				children = append(children, childDefinition{path: "/functions/" + strconv.Itoa(i), opts: child})
+			}
+		}
+	}
+	return children
+}
+
+// validateDefinitionNode validates opts against the schema of its type, if any,
+// without descending into nested definitions.
+func validateDefinitionNode(t *deviationFuncType, opts map[string]any, path string) error {
+	if errs := schemaErrors(t, opts, path); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func schemaErrors(t *deviationFuncType, opts map[string]any, path string) DefinitionErrors {
+	var errs DefinitionErrors
+	// The dust filter may be set on any type, so it is validated on its own.
+	if dust, ok := opts[dustFilterKey]; ok {
+		opts = maps.Clone(opts)
+		delete(opts, dustFilterKey)
+		errs = validateAgainst(dustFilterSchema, dust, path+"/"+dustFilterKey)
+	}
+	if t.schema != nil {
+		errs = append(errs, validateAgainst(t.schema, opts, path)...)
+	}
+	slices.SortStableFunc(errs, func(a, b DefinitionError) int {
+		return cmp.Or(cmp.Compare(a.Path, b.Path), cmp.Compare(a.Message, b.Message))
+	})
+	return errs
+}
+
+func validateAgainst(schema *jsonschema.Schema, v any, path string) DefinitionErrors {
+	// The validator only understands values as produced by encoding/json.
+	normalized, err := normalizeJSON(v)
+	if err != nil {
+		return DefinitionErrors{{Path: path, Message: err.Error()}}
+	}
+	err = schema.Validate(normalized)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return DefinitionErrors{{Path: path, Message: err.Error()}}
+	}
+	var errs DefinitionErrors
+	var flatten func(*jsonschema.ValidationError)
+	flatten = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			errs = append(errs, DefinitionError{Path: path + ve.InstanceLocation, Message: ve.Message})
+			return
+		}
+		for _, cause := range ve.Causes {
+			flatten(cause)
+		}
+	}
+	flatten(verr)
+	return errs
+}
+
+func normalizeJSON(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("definition is not valid JSON: %w", err)
+	}
+	var normalized any
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, fmt.Errorf("definition is not valid JSON: %w", err)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,161 @@
+package median
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+func Test_DeviationFuncSchema(t *testing.T) {
+	for _, name := range []string{"absolute", "all", "asymmetric", "any", "expression", "gasAware", "hysteresis", "marketHours", "pegBand", "pendle", "pendle-implied-rate", "relative", "volatilityAdaptive"} {
+		t.Run(name, func(t *testing.T) {
+			raw, ok := DeviationFuncSchema(name)
+			require.True(t, ok)
+			var schema map[string]any
+			require.NoError(t, json.Unmarshal(raw, &schema))
+			assert.Equal(t, map[string]any{"const": name}, schema["properties"].(map[string]any)["type"])
+		})
+	}
+	t.Run("unknown type", func(t *testing.T) {
+		_, ok := DeviationFuncSchema("nope")
+		assert.False(t, ok)
+	})
+}
+
+func Test_RegisterDeviationFuncSchema(t *testing.T) {
+	schema := []byte(`{"type": "object", "properties": {"type": {"const": "custom-schema"}, "level": {"type": "integer"}}, "additionalProperties": false}`)
+
+	t.Run("unregistered type", func(t *testing.T) {
+		err := RegisterDeviationFuncSchema("custom-unregistered", schema)
+		require.EqualError(t, err, "deviation function type custom-unregistered is not registered")
+	})
+	t.Run("built-in type already has a schema", func(t *testing.T) {
+		err := RegisterDeviationFuncSchema("pendle", schema)
+		require.EqualError(t, err, "deviation function type pendle already has a schema")
+	})
+	t.Run("invalid schema", func(t *testing.T) {
+		err := RegisterDeviationFuncSchema("pendle", []byte(`{`))
+		require.ErrorContains(t, err, "invalid schema for deviation function type pendle")
+	})
+	t.Run("custom type is validated against its schema", func(t *testing.T) {
+		unregisterDeviationFunc(t, "custom-schema")
+		require.NoError(t, RegisterDeviationFunc("custom-schema", func(logger.Logger, map[string]any) (median.DeviationFunc, error) {
+			return constDeviationFunc(true, nil), nil
+		}))
+		_, err := newDeviationFunc(t, map[string]any{"type": "custom-schema", "levle": float64(1)})
+		require.NoError(t, err, "without a schema only the type is checked")
+
+		require.NoError(t, RegisterDeviationFuncSchema("custom-schema", schema))
+		_, err = newDeviationFunc(t, map[string]any{"type": "custom-schema", "levle": float64(1)})
+		require.EqualError(t, err, "invalid deviation function definition: #: additionalProperties 'levle' not allowed")
+		_, err = newDeviationFunc(t, map[string]any{"type": "custom-schema", "level": float64(1)})
+		require.NoError(t, err)
+	})
+}
+
+func Test_NewDeviationFunc_RejectsUnknownKeys(t *testing.T) {
+	_, err := newDeviationFunc(t, map[string]any{"type": "pendle", "expiresAt": float64(1), "expiresat": float64(1)})
+	require.EqualError(t, err, "invalid deviation function definition: #: additionalProperties 'expiresat' not allowed")
+
+	_, err = newDeviationFunc(t, map[string]any{"type": "any", "functions": []any{
+		map[string]any{"type": "absolute", "threshold": "1", "combinerelative": true},
+	}})
+	require.EqualError(t, err, "invalid 'functions[0]' field in deviation function definition: invalid deviation function definition: #: additionalProperties 'combinerelative' not allowed")
+}
+
+func Test_ValidateDeviationDefinition(t *testing.T) {
+	t.Run("valid nested definition", func(t *testing.T) {
+		require.NoError(t, ValidateDeviationDefinition(map[string]any{"type": "any", "functions": []any{
+			map[string]any{"type": "pendle", "expiresAt": float64(1700000000), "clock": "observationTimestamp"},
+			map[string]any{"type": "absolute", "threshold": "1000"},
+		}}))
+	})
+	t.Run("missing type", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{})
+		require.EqualError(t, err, "invalid deviation function definition: #/type: missing or invalid 'type' field")
+	})
+	t.Run("unknown type", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{"type": "pendel"})
+		require.EqualError(t, err, "invalid deviation function definition: #/type: unsupported function type: pendel")
+	})
+	t.Run("reports every problem with its path", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{"type": "all", "functions": []any{
+			map[string]any{"type": "pendle", "expiresat": float64(1), "clock": "sundial"},
+			map[string]any{"type": "relative", "zeroPolicy": "absoluteFloor", "decimals": 8.5},
+			map[string]any{"type": "absolute", "threshold": "-1"},
+		}})
+		var errs DefinitionErrors
+		require.True(t, errors.As(err, &errs))
+
+		paths := map[string]int{}
+		for _, e := range errs {
+			paths[e.Path]++
+		}
+		assert.Equal(t, map[string]int{
+			"/functions/0":           2, // missing expiresAt, unknown expiresat
+			"/functions/0/clock":     1,
+			"/functions/1":           1, // missing zeroFloor
+			"/functions/1/decimals":  1,
+			"/functions/2/threshold": 1,
+		}, paths, err.Error())
+	})
+	t.Run("accepts the factory settings of the job configuration", func(t *testing.T) {
+		require.NoError(t, ValidateDeviationDefinition(map[string]any{
+			"type":            "relative",
+			"zeroPolicy":      "alwaysUpdate",
+			"aggregator":      map[string]any{"method": "median"},
+			"outlierFilter":   map[string]any{"k": float64(3)},
+			"timestampPolicy": map[string]any{"method": "max"},
+			"reportVersion":   float64(3),
+		}))
+		require.NoError(t, ValidateDeviationDefinition(map[string]any{"reportVersion": float64(2)}), "the deviation function is optional")
+	})
+	t.Run("reports problems in factory settings with their path", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{
+			"type":          "pendel",
+			"aggregator":    map[string]any{"method": "mode"},
+			"reportVersion": float64(9),
+		})
+		require.EqualError(t, err, "invalid deviation function definition: "+
+			"#/aggregator: unsupported method in aggregator definition: mode; "+
+			"#/reportVersion: invalid 'reportVersion' field in factory configuration: 9; "+
+			"#/type: unsupported function type: pendel")
+	})
+	t.Run("outlier filter requires report version 2", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{"outlierFilter": map[string]any{"k": float64(3)}})
+		require.EqualError(t, err, "invalid deviation function definition: #/outlierFilter: an outlier filter requires report version 2 or later to record the rejected observers, got 1")
+		err = ValidateDeviationDefinition(map[string]any{"outlierFilter": map[string]any{"k": float64(3)}, "reportVersion": float64(1)})
+		require.Error(t, err)
+		require.NoError(t, ValidateDeviationDefinition(map[string]any{"outlierFilter": map[string]any{"k": float64(3)}, "reportVersion": float64(2)}))
+	})
+	t.Run("validates the outlier filter against its schema", func(t *testing.T) {
+		require.NoError(t, ValidateDeviationDefinition(map[string]any{
+			"outlierFilter": map[string]any{"k": float64(3), "minTolerancePPB": float64(1e7), "minObservations": float64(3)},
+			"reportVersion": float64(2),
+		}))
+		err := ValidateDeviationDefinition(map[string]any{
+			"outlierFilter": map[string]any{"k": float64(3), "minTolerancePPB": float64(2e9), "tolerance": float64(1)},
+			"reportVersion": float64(2),
+		})
+		var errs DefinitionErrors
+		require.ErrorAs(t, err, &errs)
+		paths := map[string]int{}
+		for _, e := range errs {
+			paths[e.Path]++
+		}
+		assert.Equal(t, map[string]int{
+			"/outlierFilter":                 1, // unknown tolerance
+			"/outlierFilter/minTolerancePPB": 1,
+		}, paths, err.Error())
+	})
+	t.Run("reports constructor errors", func(t *testing.T) {
+		err := ValidateDeviationDefinition(map[string]any{"type": "relative", "zeroPolicy": "alwaysUpdate", "decimals": float64(8), "roundToDecimals": float64(9)})
+		require.EqualError(t, err, "invalid deviation function definition: #: invalid 'roundToDecimals' field in deviation function definition: 9 exceeds 'decimals' 8")
+	})
+}
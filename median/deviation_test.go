@@ -1,13 +1,18 @@
 package median
 
 import (
+	"context"
+	"math"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/smartcontractkit/chainlink-common/pkg/logger"
 )
@@ -25,14 +30,45 @@ func valFromString(t *testing.T, v string) *big.Int {
 	return d.BigInt()
 }
 
+// newDeviationFunc calls NewDeviationFunc, and checks that the constructor of the
+// type rejects every definition its schema rejects, so the two do not drift
+// apart. Unknown fields are left to the schema. For definitions both reject it
+// returns the error of the constructor, which names the field.
+func newDeviationFunc(t *testing.T, opts map[string]any) (median.DeviationFunc, error) {
+	t.Helper()
+	f, err := NewDeviationFunc(logger.Test(t), opts)
+	typeVal, _ := opts["type"].(string)
+	typ, ok := lookupDeviationFunc(typeVal)
+	if !ok {
+		return f, err
+	}
+	var constraintErrs DefinitionErrors
+	for _, e := range schemaErrors(typ, opts, "") {
+		if !strings.HasPrefix(e.Message, "additionalProperties") {
+			constraintErrs = append(constraintErrs, e)
+		}
+	}
+	if len(constraintErrs) == 0 {
+		return f, err
+	}
+	require.Error(t, err, "the schema is checked first")
+	_, ctorErr := constructDeviationFunc(logger.Test(t), typ, opts)
+	require.Error(t, ctorErr, "the constructor accepts a definition the schema rejects: %v", constraintErrs)
+	return nil, ctorErr
+}
+
 func Test_NewDeviationFunc(t *testing.T) {
 	t.Run("missing type field", func(t *testing.T) {
 		_, err := NewDeviationFunc(logger.Test(t), map[string]interface{}{})
 		require.EqualError(t, err, "missing or invalid 'type' field in deviation function definition")
 	})
+	t.Run("misspelled field is unknown rather than missing", func(t *testing.T) {
+		_, err := NewDeviationFunc(logger.Test(t), map[string]any{"type": "relative", "zeropolicy": "alwaysUpdate"})
+		require.EqualError(t, err, "invalid deviation function definition: #: additionalProperties 'zeropolicy' not allowed; #: missing properties: 'zeroFloor'; #: missing properties: 'zeroPolicy'")
+	})
 	t.Run("pendle - valid", func(t *testing.T) {
 		expiresAt := float64(13857541.0) + float64(time.Now().Unix())
-		f, err := NewDeviationFunc(logger.Test(t), map[string]interface{}{
+		f, err := newDeviationFunc(t, map[string]interface{}{
 			"type":      "pendle",
 			"expiresAt": expiresAt,
 		})
@@ -47,7 +83,7 @@ func Test_NewDeviationFunc(t *testing.T) {
 		assert.False(t, deviates)
 	})
 	t.Run("pendle - with multiplier", func(t *testing.T) {
-		f, err := NewDeviationFunc(logger.Test(t), map[string]interface{}{
+		f, err := newDeviationFunc(t, map[string]interface{}{
 			"type":       "pendle",
 			"expiresAt":  float64(13857541.0) + float64(time.Now().Unix()),
 			"multiplier": "1000",
@@ -183,7 +219,7 @@ func Test_PendleDeviationFunc(t *testing.T) {
 
 			clock := frozenTimeClock{}
 			expiresAt := float64(clock.Now().Unix()) + tc.expiresInSeconds
-			actual, err := makePendleDeviationFunc(logger.Test(t), expiresAt, clock, DefaultMultiplier)(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			actual, err := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{expiresAt: expiresAt, timeSource: TimeSourceSystem, multiplier: DefaultMultiplier})(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
 			if tc.err != "" {
 				require.EqualError(t, err, tc.err)
 			} else {
@@ -203,3 +239,290 @@ func Test_PendleDeviationFunc(t *testing.T) {
 		})
 	}
 }
+
+func Test_PendleDeviationFunc_Expiry(t *testing.T) {
+	clock := frozenTimeClock{}
+	now := float64(clock.Now().Unix())
+	oldVal := valFromString(t, "0.187152977881070687")
+	newVal := valFromString(t, "0.164498448931278907")
+
+	tcs := []struct {
+		name string
+
+		expiresInSeconds  float64
+		postExpiry        PostExpiryPolicy
+		minHorizonSeconds float64
+		thresholdPPB      uint64
+		oldVal            *big.Int
+		newVal            *big.Int
+
+		expected bool
+	}{
+		{
+			name:             "freeze after expiry - SHOULD NOT UPDATE",
+			expiresInSeconds: -1,
+			postExpiry:       PostExpiryFreeze,
+			thresholdPPB:     1,
+			oldVal:           oldVal,
+			newVal:           newVal,
+			expected:         false,
+		},
+		{
+			name:             "alwaysUpdate after expiry - SHOULD UPDATE",
+			expiresInSeconds: -1,
+			postExpiry:       PostExpiryAlwaysUpdate,
+			thresholdPPB:     1e9,
+			oldVal:           oldVal,
+			newVal:           new(big.Int).Add(oldVal, big.NewInt(1)),
+			expected:         true,
+		},
+		{
+			name:             "alwaysUpdate after expiry, unchanged - SHOULD NOT UPDATE",
+			expiresInSeconds: -1,
+			postExpiry:       PostExpiryAlwaysUpdate,
+			oldVal:           oldVal,
+			newVal:           oldVal,
+			expected:         false,
+		},
+		{
+			name:             "fallbackRelative after expiry, above threshold - SHOULD UPDATE",
+			expiresInSeconds: 0,
+			postExpiry:       PostExpiryFallbackRelative,
+			thresholdPPB:     1e8,
+			oldVal:           oldVal,
+			newVal:           newVal,
+			expected:         true,
+		},
+		{
+			name:             "fallbackRelative after expiry, below threshold - SHOULD NOT UPDATE",
+			expiresInSeconds: 0,
+			postExpiry:       PostExpiryFallbackRelative,
+			thresholdPPB:     2e8,
+			oldVal:           oldVal,
+			newVal:           newVal,
+			expected:         false,
+		},
+		{
+			name:             "near expiry without grace window - SHOULD NOT UPDATE",
+			expiresInSeconds: 60,
+			thresholdPPB:     1e7,
+			oldVal:           oldVal,
+			newVal:           newVal,
+			expected:         false,
+		},
+		{
+			name:              "near expiry inside grace window uses minimum horizon - SHOULD UPDATE",
+			expiresInSeconds:  60,
+			minHorizonSeconds: 180 * 24 * 60 * 60,
+			thresholdPPB:      1e7,
+			oldVal:            oldVal,
+			newVal:            newVal,
+			expected:          true,
+		},
+		{
+			name:              "outside grace window is unaffected - SHOULD UPDATE",
+			expiresInSeconds:  13857541.0,
+			minHorizonSeconds: 60,
+			thresholdPPB:      1e7,
+			oldVal:            oldVal,
+			newVal:            newVal,
+			expected:          true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{
+				expiresAt:         now + tc.expiresInSeconds,
+				timeSource:        TimeSourceSystem,
+				multiplier:        DefaultMultiplier,
+				postExpiry:        tc.postExpiry,
+				minHorizonSeconds: tc.minHorizonSeconds,
+			})
+			actual, err := f(nil, tc.thresholdPPB, tc.oldVal, tc.newVal)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+type mutableClock struct {
+	now time.Time
+}
+
+func (c *mutableClock) Now() time.Time {
+	return c.now
+}
+
+func Test_PendleDeviationFunc_ExpiryTransitionsLoggedOnce(t *testing.T) {
+	start := frozenTimeClock{}.Now()
+	clock := &mutableClock{now: start}
+	lggr, logs := logger.TestObserved(t, zapcore.InfoLevel)
+	f := makePendleDeviationFunc(lggr, clock, pendleConfig{
+		expiresAt:         float64(start.Add(time.Hour).Unix()),
+		timeSource:        TimeSourceSystem,
+		multiplier:        DefaultMultiplier,
+		postExpiry:        PostExpiryFreeze,
+		minHorizonSeconds: 600,
+	})
+
+	for _, offset := range []time.Duration{0, time.Minute, 55 * time.Minute, 56 * time.Minute, time.Hour, 2 * time.Hour} {
+		clock.now = start.Add(offset)
+		_, err := f(nil, 1e7, big.NewInt(1), big.NewInt(2))
+		require.NoError(t, err)
+	}
+
+	transitions := logs.FilterMessage("Pendle expiry phase changed").All()
+	require.Len(t, transitions, 2)
+	assert.Equal(t, "active", transitions[0].ContextMap()["from"])
+	assert.Equal(t, "grace", transitions[0].ContextMap()["to"])
+	assert.Equal(t, "grace", transitions[1].ContextMap()["from"])
+	assert.Equal(t, "expired", transitions[1].ContextMap()["to"])
+}
+
+func Test_PendleDeviationFunc_ExpiryTrackedByReportStage(t *testing.T) {
+	start := frozenTimeClock{}.Now()
+	lggr, logs := logger.TestObserved(t, zapcore.InfoLevel)
+	f := makePendleDeviationFunc(lggr, frozenTimeClock{}, pendleConfig{
+		expiresAt:  float64(start.Add(time.Hour).Unix()),
+		timeSource: TimeSourceSystem,
+		multiplier: DefaultMultiplier,
+		postExpiry: PostExpiryFreeze,
+	})
+	evaluate := func(stage deviationStage, now time.Time) {
+		ctx := withDeviationRound(context.Background(), &deviationRound{stage: stage, clock: &mutableClock{now: now}})
+		_, err := f(ctx, 1e7, big.NewInt(1), big.NewInt(2))
+		require.NoError(t, err)
+	}
+
+	// The stages disagree about the time around expiry, which must not flap the phase.
+	for range 3 {
+		evaluate(deviationStageReport, start)
+		evaluate(deviationStageAccept, start.Add(2*time.Hour))
+	}
+	assert.Empty(t, logs.FilterMessage("Pendle expiry phase changed").All())
+
+	evaluate(deviationStageReport, start.Add(2*time.Hour))
+	transitions := logs.FilterMessage("Pendle expiry phase changed").All()
+	require.Len(t, transitions, 1)
+	assert.Equal(t, "expired", transitions[0].ContextMap()["to"])
+}
+
+func Test_NewDeviationFunc_PendleExpiryOptions(t *testing.T) {
+	t.Run("invalid postExpiry", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle", "expiresAt": float64(1), "postExpiry": "thaw"})
+		require.EqualError(t, err, "invalid 'postExpiry' field in deviation function definition: thaw")
+	})
+	t.Run("negative minHorizonSeconds", func(t *testing.T) {
+		_, err := newDeviationFunc(t, map[string]any{"type": "pendle", "expiresAt": float64(1), "minHorizonSeconds": float64(-1)})
+		require.EqualError(t, err, "invalid 'minHorizonSeconds' field in deviation function definition: -1")
+	})
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, map[string]any{"type": "pendle", "expiresAt": float64(1), "postExpiry": "alwaysUpdate", "minHorizonSeconds": float64(86400)})
+		require.NoError(t, err)
+		deviates, err := f(nil, 1e7, big.NewInt(1), big.NewInt(2))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+}
+
+func Test_NewDeviationFunc_PendleScaling(t *testing.T) {
+	expiresAt := float64(13857541.0) + float64(time.Now().Unix())
+	tcs := []struct {
+		name string
+		opts map[string]any
+		err  string
+	}{
+		{name: "zero multiplier", opts: map[string]any{"multiplier": "0"}, err: "invalid 'multiplier' field in deviation function definition: must be positive, got 0"},
+		{name: "negative multiplier", opts: map[string]any{"multiplier": "-1000"}, err: "invalid 'multiplier' field in deviation function definition: must be positive, got -1000"},
+		{name: "huge multiplier", opts: map[string]any{"multiplier": strings.Repeat("9", 1e6)}, err: "invalid 'multiplier' field in deviation function definition: exceeds 256 bits"},
+		{name: "non-string multiplier", opts: map[string]any{"multiplier": float64(1000)}, err: "missing or invalid 'multiplier' field in deviation function definition"},
+		{name: "multiplier and decimals", opts: map[string]any{"multiplier": "1000", "decimals": float64(3)}, err: "only one of 'multiplier' and 'decimals' may be set in deviation function definition"},
+		{name: "fractional decimals", opts: map[string]any{"decimals": 3.5}, err: "missing or invalid 'decimals' field in deviation function definition"},
+		{name: "too many decimals", opts: map[string]any{"decimals": float64(78)}, err: "invalid 'decimals' field in deviation function definition: 10^78 exceeds 256 bits"},
+		{name: "negative expiresAt", opts: map[string]any{"expiresAt": float64(-1)}, err: "invalid 'expiresAt' field in deviation function definition: -1"},
+		{name: "expiresAt after year 9999", opts: map[string]any{"expiresAt": 1e300}, err: "invalid 'expiresAt' field in deviation function definition: 1e+300"},
+		{name: "infinite minHorizonSeconds", opts: map[string]any{"minHorizonSeconds": math.Inf(1)}, err: "invalid 'minHorizonSeconds' field in deviation function definition: +Inf"},
+		{name: "NaN minHorizonSeconds", opts: map[string]any{"minHorizonSeconds": math.NaN()}, err: "invalid 'minHorizonSeconds' field in deviation function definition: NaN"},
+		{name: "decimals", opts: map[string]any{"decimals": float64(3)}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := map[string]any{"type": "pendle", "expiresAt": expiresAt}
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+			f, err := newDeviationFunc(t, opts)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			// Same values as "pendle - with multiplier", scaled by 10^3 instead of 10^18
+			deviates, err := f(nil, 1e7, big.NewInt(187), big.NewInt(160))
+			require.NoError(t, err)
+			assert.True(t, deviates)
+			deviates, err = f(nil, 1e7, big.NewInt(187), big.NewInt(178))
+			require.NoError(t, err)
+			assert.False(t, deviates)
+		})
+	}
+}
+
+// Test_PendleDeviationFunc_Conformance pins the big.Float computation for the edge
+// cases of Test_PendleDeviationFunc against reference values computed in decimal
+// arithmetic at 60 significant digits.
+func Test_PendleDeviationFunc_Conformance(t *testing.T) {
+	const logThreshold = "0.00995033085316808284821535754426074168867960994005879786460956"
+
+	tcs := []struct {
+		name string
+
+		expiresInSeconds float64
+		oldVal           *big.Int
+		newVal           *big.Int
+
+		product  string
+		expected bool
+	}{
+		{
+			name:             "test 4 edge case",
+			expiresInSeconds: 11564856.999999998137354850769,
+			oldVal:           valFromString(t, "0.141802025539163406575582371261"),
+			newVal:           valFromString(t, "0.114668695429674297181499298404"),
+			product:          "0.00995031337677688637247631278538812785388127853881278538812785",
+			expected:         false,
+		},
+		{
+			name:             "test 9 EDGE CASE",
+			expiresInSeconds: 3574116.99999999860301613807678,
+			oldVal:           valFromString(t, "0.114668136842518697537940397524"),
+			newVal:           valFromString(t, "0.202462661212593902915202193071"),
+			product:          "0.00995014910128107817950865677321156773211567732115677321156773",
+			expected:         false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := frozenTimeClock{}
+			expiry := &expiryTracker{lggr: logger.Test(t), cfg: pendleConfig{expiresAt: float64(clock.Now().Unix()) + tc.expiresInSeconds}}
+			years, phase := expiry.horizon(nil, clock.Now())
+			require.Equal(t, expiryPhaseActive, phase)
+
+			d := computePendleDeviation(1e7, tc.oldVal, tc.newVal, DefaultMultiplier, years)
+			expectedProduct, ok := newFloat().SetString(tc.product)
+			require.True(t, ok)
+			expectedLogThreshold, ok := newFloat().SetString(logThreshold)
+			require.True(t, ok)
+
+			assert.Equal(t, expectedProduct.Text('g', 50), d.product.Text('g', 50))
+			assert.Equal(t, expectedLogThreshold.Text('g', 50), d.logThreshold.Text('g', 50))
+			assert.Equal(t, tc.expected, d.deviates())
+
+			actual, err := makePendleDeviationFunc(logger.Test(t), clock, pendleConfig{expiresAt: expiry.cfg.expiresAt, timeSource: TimeSourceSystem, multiplier: DefaultMultiplier})(nil, 1e7, tc.oldVal, tc.newVal)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package median
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+)
+
+// MaxVolatilityWindow bounds the number of values a volatilityAdaptive deviation func keeps.
+const MaxVolatilityWindow = 1000
+
+type volatilityConfig struct {
+	windowSize int
+	// referenceVolatilityPPB is the realized volatility at which thresholdPPB applies unscaled
+	referenceVolatilityPPB uint64
+	floorPPB               uint64
+	capPPB                 uint64
+}
+
+func newVolatilityDeviationFunc(lggr logger.Logger, opts map[string]any) (median.DeviationFunc, error) {
+	var cfg volatilityConfig
+	windowSize, err := integerOption(opts, "windowSize")
+	if err != nil {
+		return nil, err
+	}
+	if windowSize < 2 || windowSize > MaxVolatilityWindow {
+		return nil, fmt.Errorf("invalid 'windowSize' field in deviation function definition: must be between 2 and %d, got %d", MaxVolatilityWindow, windowSize)
+	}
+	cfg.windowSize = int(windowSize)
+	if cfg.referenceVolatilityPPB, err = integerOption(opts, "referenceVolatilityPPB"); err != nil {
+		return nil, err
+	}
+	if cfg.referenceVolatilityPPB == 0 {
+		return nil, errors.New("invalid 'referenceVolatilityPPB' field in deviation function definition: must be positive")
+	}
+	if cfg.floorPPB, err = integerOption(opts, "floorPPB"); err != nil {
+		return nil, err
+	}
+	if cfg.capPPB, err = integerOption(opts, "capPPB"); err != nil {
+		return nil, err
+	}
+	if cfg.floorPPB > cfg.capPPB {
+		return nil, fmt.Errorf("invalid 'floorPPB' field in deviation function definition: %d exceeds 'capPPB' %d", cfg.floorPPB, cfg.capPPB)
+	}
+	return makeVolatilityDeviationFunc(lggr, cfg), nil
+}
+
+// volatilityWindow is a bounded window of recently seen values, reset when the
+// config digest changes.
+type volatilityWindow struct {
+	mu           sync.Mutex
+	configDigest ocrtypes.ConfigDigest
+	values       []*big.Int
+}
+
+// observe returns a copy of the values seen so far and then, if record is set,
+// appends v, dropping the oldest value if the window is full.
+func (w *volatilityWindow) observe(round *deviationRound, v *big.Int, size int, record bool) []*big.Int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if round != nil && round.configDigest != w.configDigest {
+		// A new configuration may change the feed entirely, so start over.
+		w.configDigest = round.configDigest
+		w.values = nil
+	}
+	seen := make([]*big.Int, len(w.values))
+	copy(seen, w.values)
+	if !record {
+		return seen
+	}
+	w.values = append(w.values, new(big.Int).Set(v))
+	if len(w.values) > size {
+		w.values = w.values[len(w.values)-size:]
+	}
+	return seen
+}
+
+// realizedVolatilityPPB returns the root mean square of the relative changes
+// between consecutive values, in parts per billion. Changes from zero are skipped.
+// It returns false if there are no changes to measure.
+func realizedVolatilityPPB(values []*big.Int) (*big.Float, bool) {
+	sumSquares := newFloat()
+	n := 0
+	for i := 1; i < len(values); i++ {
+		if values[i-1].Sign() == 0 {
+			continue
+		}
+		r := floatFromRatio(new(big.Int).Sub(values[i], values[i-1]), values[i-1])
+		sumSquares.Add(sumSquares, newFloat().Mul(r, r))
+		n++
+	}
+	if n == 0 {
+		return nil, false
+	}
+	meanSquare := sumSquares.Quo(sumSquares, newFloat().SetInt64(int64(n)))
+	vol := newFloat().Sqrt(meanSquare)
+	return vol.Mul(vol, newFloat().SetInt64(1e9)), true
+}
+
+// makeVolatilityDeviationFunc makes a deviation func whose effective threshold is
+// thresholdPPB scaled by the realized volatility of recently seen values relative
+// to cfg.referenceVolatilityPPB, clamped to [cfg.floorPPB, cfg.capPPB].
+//
+// The window holds the values evaluated when building reports. The accept stage
+// reads it without adding its value, which the report stage has already seen.
+//
+// NOTE: The window holds the values this oracle has evaluated, which may differ
+// between oracles, e.g. when one missed rounds while restarting.
+func makeVolatilityDeviationFunc(lggr logger.Logger, cfg volatilityConfig) median.DeviationFunc {
+	window := &volatilityWindow{}
+	return func(ctx context.Context, thresholdPPB uint64, oldVal, newVal *big.Int) (bool, error) {
+		if oldVal == nil || newVal == nil {
+			return false, errors.New("oldVal and newVal must be non-nil")
+		}
+
+		// The current value is left out so a spike cannot widen its own threshold.
+		round := deviationRoundFromContext(ctx)
+		record := round == nil || round.stage != deviationStageAccept
+		seen := window.observe(round, newVal, cfg.windowSize, record)
+
+		scaled := new(big.Int).SetUint64(thresholdPPB)
+		vol, ok := realizedVolatilityPPB(seen)
+		if ok {
+			scaledF := newFloat().Mul(floatFromInt(scaled), vol)
+			scaledF.Quo(scaledF, floatFromInt(new(big.Int).SetUint64(cfg.referenceVolatilityPPB)))
+			scaled, _ = scaledF.Int(nil)
+		}
+		effectivePPB := clampPPB(scaled, cfg.floorPPB, cfg.capPPB)
+
+		var deviates bool
+		if oldVal.Sign() == 0 {
+			deviates = newVal.Sign() != 0
+		} else {
+			deviates = relativeDeviates(effectivePPB, oldVal, newVal)
+		}
+
+		volS := "n/a"
+		if ok {
+			volS = vol.Text('f', 3)
+		}
+		decision := newDeviationDecision("volatilityAdaptive", thresholdPPB, oldVal, newVal)
+		decision.Deviates = deviates
+		decision.Intermediates["windowValues"] = len(seen)
+		decision.Intermediates["realizedVolatilityPPB"] = volS
+		decision.Intermediates["referenceVolatilityPPB"] = cfg.referenceVolatilityPPB
+		decision.Intermediates["floorPPB"] = cfg.floorPPB
+		decision.Intermediates["capPPB"] = cfg.capPPB
+		decision.Intermediates["effectiveThresholdPPB"] = effectivePPB
+		recordDecision(ctx, lggr, decision)
+		return deviates, nil
+	}
+}
+
+func clampPPB(v *big.Int, floor, ceiling uint64) uint64 {
+	if v.Cmp(new(big.Int).SetUint64(floor)) < 0 {
+		return floor
+	}
+	if v.Cmp(new(big.Int).SetUint64(ceiling)) > 0 {
+		return ceiling
+	}
+	return v.Uint64()
+}
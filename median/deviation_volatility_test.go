@@ -0,0 +1,149 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+
+	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+	"github.com/smartcontractkit/chainlink-common/pkg/utils/tests"
+)
+
+func Test_NewDeviationFunc_Volatility(t *testing.T) {
+	valid := func() map[string]any {
+		return map[string]any{"type": "volatilityAdaptive", "windowSize": float64(10), "referenceVolatilityPPB": float64(1e7), "floorPPB": float64(1e6), "capPPB": float64(1e8)}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		f, err := newDeviationFunc(t, valid())
+		require.NoError(t, err)
+		require.NotNil(t, f)
+	})
+	t.Run("window too small", func(t *testing.T) {
+		opts := valid()
+		opts["windowSize"] = float64(1)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'windowSize' field in deviation function definition: must be between 2 and 1000, got 1")
+	})
+	t.Run("window too large", func(t *testing.T) {
+		opts := valid()
+		opts["windowSize"] = float64(1001)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'windowSize' field in deviation function definition: must be between 2 and 1000, got 1001")
+	})
+	t.Run("zero reference volatility", func(t *testing.T) {
+		opts := valid()
+		opts["referenceVolatilityPPB"] = float64(0)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'referenceVolatilityPPB' field in deviation function definition: must be positive")
+	})
+	t.Run("missing cap", func(t *testing.T) {
+		opts := valid()
+		delete(opts, "capPPB")
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "missing or invalid 'capPPB' field in deviation function definition")
+	})
+	t.Run("floor above cap", func(t *testing.T) {
+		opts := valid()
+		opts["floorPPB"] = float64(2e8)
+		_, err := newDeviationFunc(t, opts)
+		require.EqualError(t, err, "invalid 'floorPPB' field in deviation function definition: 200000000 exceeds 'capPPB' 100000000")
+	})
+}
+
+func Test_realizedVolatilityPPB(t *testing.T) {
+	_, ok := realizedVolatilityPPB(nil)
+	assert.False(t, ok)
+	_, ok = realizedVolatilityPPB([]*big.Int{big.NewInt(0), big.NewInt(5)})
+	assert.False(t, ok, "changes from zero are skipped")
+
+	vol, ok := realizedVolatilityPPB([]*big.Int{big.NewInt(100), big.NewInt(110), big.NewInt(99)})
+	require.True(t, ok)
+	assert.Equal(t, "100000000", vol.Text('f', 0))
+}
+
+func Test_VolatilityDeviationFunc(t *testing.T) {
+	cfg := volatilityConfig{windowSize: 4, referenceVolatilityPPB: 1e7, floorPPB: 2e6, capPPB: 3e7}
+	const thresholdPPB = 1e7
+
+	t.Run("calm window tightens the threshold down to the floor", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		for _, v := range []int64{100_000, 100_010, 100_000, 100_010} {
+			_, err := f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(v))
+			require.NoError(t, err)
+		}
+		// 0.5% is below the configured 1% but above the 0.2% floor
+		deviates, err := f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(100_500))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+	t.Run("volatile window widens the threshold up to the cap", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		for _, v := range []int64{100_000, 110_000, 100_000, 110_000} {
+			_, err := f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(v))
+			require.NoError(t, err)
+		}
+		// 2% is above the configured 1% but below the 3% cap
+		deviates, err := f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(102_000))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+		deviates, err = f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(103_000))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+	t.Run("without history the configured threshold applies", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		deviates, err := f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(100_999))
+		require.NoError(t, err)
+		assert.False(t, deviates)
+		deviates, err = f(nil, thresholdPPB, big.NewInt(100_000), big.NewInt(101_000))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+	t.Run("window is reset when the config digest changes", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		ctxA := withDeviationRound(tests.Context(t), &deviationRound{configDigest: ocrtypes.ConfigDigest{1}})
+		for _, v := range []int64{100_000, 110_000, 100_000, 110_000} {
+			_, err := f(ctxA, thresholdPPB, big.NewInt(100_000), big.NewInt(v))
+			require.NoError(t, err)
+		}
+		deviates, err := f(ctxA, thresholdPPB, big.NewInt(100_000), big.NewInt(102_000))
+		require.NoError(t, err)
+		assert.False(t, deviates, "volatile history widens the threshold")
+
+		ctxB := withDeviationRound(tests.Context(t), &deviationRound{configDigest: ocrtypes.ConfigDigest{2}})
+		deviates, err = f(ctxB, thresholdPPB, big.NewInt(100_000), big.NewInt(102_000))
+		require.NoError(t, err)
+		assert.True(t, deviates, "history from the previous config is dropped")
+	})
+	t.Run("accept stage does not add to the window", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		report := withDeviationRound(tests.Context(t), &deviationRound{stage: deviationStageReport})
+		accept := withDeviationRound(tests.Context(t), &deviationRound{stage: deviationStageAccept})
+		for _, v := range []int64{100_000, 100_010} {
+			_, err := f(report, thresholdPPB, big.NewInt(100_000), big.NewInt(v))
+			require.NoError(t, err)
+			_, err = f(accept, thresholdPPB, big.NewInt(100_000), big.NewInt(v))
+			require.NoError(t, err)
+		}
+		decisions := NewDecisionRing(1)
+		_, err := f(withDeviationRound(tests.Context(t), &deviationRound{stage: deviationStageAccept, decisions: decisions}), thresholdPPB, big.NewInt(100_000), big.NewInt(100_000))
+		require.NoError(t, err)
+		require.Len(t, decisions.Decisions(), 1)
+		assert.Equal(t, 2, decisions.Decisions()[0].Intermediates["windowValues"])
+	})
+	t.Run("zero old value", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		deviates, err := f(nil, thresholdPPB, big.NewInt(0), big.NewInt(1))
+		require.NoError(t, err)
+		assert.True(t, deviates)
+	})
+	t.Run("nil values error", func(t *testing.T) {
+		f := makeVolatilityDeviationFunc(logger.Test(t), cfg)
+		_, err := f(nil, thresholdPPB, nil, big.NewInt(1))
+		require.EqualError(t, err, "oldVal and newVal must be non-nil")
+	})
+}
@@ -0,0 +1,518 @@
+package median
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// MaxExpressionLength bounds the length of an expression's source text.
+	MaxExpressionLength = 4096
+	// MaxExpressionNodes bounds the number of operations and operands in an expression.
+	MaxExpressionNodes = 256
+	// MaxExpressionValueBits bounds the size of the numerator and denominator of
+	// any value computed while evaluating an expression.
+	MaxExpressionValueBits = 4096
+)
+
+// exprKind is the type of an expression, checked when it is compiled.
+type exprKind int
+
+const (
+	exprNumber exprKind = iota
+	exprBool
+)
+
+func (k exprKind) String() string {
+	if k == exprBool {
+		return "boolean"
+	}
+	return "number"
+}
+
+// exprValue is the result of evaluating an expression node. Numbers are exact
+// rationals, only ln and sqrt round, at deviationPrec.
+type exprValue struct {
+	num     *big.Rat
+	boolean bool
+}
+
+// exprEnv holds the variables an expression is evaluated with.
+type exprEnv map[string]*big.Rat
+
+type exprNode interface {
+	kind() exprKind
+	eval(env exprEnv) (exprValue, error)
+}
+
+// expression is a compiled arithmetic expression. It cannot perform I/O, has no
+// loops, and is bounded in size, so evaluating it is cheap and deterministic.
+type expression struct {
+	root exprNode
+	// variables lists the variables the expression reads
+	variables map[string]bool
+}
+
+// compileExpression parses src and checks its types. Only the identifiers in
+// variables may be referenced, and the expression must evaluate to resultKind.
+func compileExpression(src string, variables map[string]bool, resultKind exprKind) (*expression, error) {
+	if len(src) > MaxExpressionLength {
+		return nil, fmt.Errorf("expression exceeds %d characters", MaxExpressionLength)
+	}
+	tokens, err := lexExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, variables: variables, used: map[string]bool{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+	if root.kind() != resultKind {
+		return nil, fmt.Errorf("expression must evaluate to a %s, not a %s", resultKind, root.kind())
+	}
+	return &expression{root: root, variables: p.used}, nil
+}
+
+func (e *expression) eval(env exprEnv) (exprValue, error) {
+	return e.root.eval(env)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// exprOperators are matched longest first.
+var exprOperators = []string{"<=", ">=", "==", "!=", "&&", "||", "+", "-", "*", "/", "<", ">", "!", "(", ")", ","}
+
+func lexExpression(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c):
+			start := i
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				i++
+				if i == len(src) || !isDigit(src[i]) {
+					return nil, fmt.Errorf("invalid number at offset %d", start)
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i-start > 100 {
+				return nil, fmt.Errorf("number at offset %d exceeds 100 characters", start)
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, src[start:i], start})
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{tokenOperator, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "end of expression", len(src)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// exprParser is a recursive descent parser. From lowest to highest precedence:
+// ||, &&, comparisons, + and -, * and /, unary - and !.
+type exprParser struct {
+	tokens    []token
+	pos       int
+	nodes     int
+	variables map[string]bool
+	used      map[string]bool
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at offset %d, got %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+// node counts n towards MaxExpressionNodes.
+func (p *exprParser) node(n exprNode) (exprNode, error) {
+	p.nodes++
+	if p.nodes > MaxExpressionNodes {
+		return nil, fmt.Errorf("expression exceeds %d nodes", MaxExpressionNodes)
+	}
+	return n, nil
+}
+
+func (p *exprParser) binary(next func() (exprNode, error), operandKind exprKind, ops ...string) (exprNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != operandKind || right.kind() != operandKind {
+			return nil, fmt.Errorf("operator %q at offset %d requires %s operands", op, pos, operandKind)
+		}
+		if left, err = p.node(&binaryNode{op: op, left: left, right: right}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.binary(p.parseAnd, exprBool, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.binary(p.parseComparison, exprBool, "&&")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	pos := p.peek().pos
+	op, ok := p.accept("<=", ">=", "==", "!=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if left.kind() != exprNumber || right.kind() != exprNumber {
+		return nil, fmt.Errorf("operator %q at offset %d requires number operands", op, pos)
+	}
+	return p.node(&binaryNode{op: op, left: left, right: right})
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	return p.binary(p.parseProduct, exprNumber, "+", "-")
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	return p.binary(p.parseUnary, exprNumber, "*", "/")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	pos := p.peek().pos
+	op, ok := p.accept("-", "!")
+	if !ok {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if (op == "-") != (operand.kind() == exprNumber) {
+		return nil, fmt.Errorf("operator %q at offset %d cannot be applied to a %s", op, pos, operand.kind())
+	}
+	return p.node(&unaryNode{op: op, operand: operand})
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		v, ok := new(big.Rat).SetString(t.text)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q at offset %d", t.text, t.pos)
+		}
+		return p.node(&literalNode{value: exprValue{num: v}, k: exprNumber})
+	case tokenIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		switch t.text {
+		case "true", "false":
+			return p.node(&literalNode{value: exprValue{boolean: t.text == "true"}, k: exprBool})
+		}
+		if !p.variables[t.text] {
+			return nil, fmt.Errorf("unknown identifier %q at offset %d", t.text, t.pos)
+		}
+		p.used[t.text] = true
+		return p.node(&variableNode{name: t.text})
+	case tokenOperator:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+// exprFunctions maps the built-in functions to their number of arguments.
+var exprFunctions = map[string]int{"abs": 1, "min": 2, "max": 2, "ln": 1, "sqrt": 1}
+
+func (p *exprParser) parseCall(name token) (exprNode, error) {
+	arity, ok := exprFunctions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.pos)
+	}
+	var args []exprNode
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if arg.kind() != exprNumber {
+				return nil, fmt.Errorf("function %q at offset %d requires number arguments", name.text, name.pos)
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("function %q at offset %d takes %d arguments, got %d", name.text, name.pos, arity, len(args))
+	}
+	return p.node(&callNode{name: name.text, args: args})
+}
+
+type literalNode struct {
+	value exprValue
+	k     exprKind
+}
+
+func (n *literalNode) kind() exprKind { return n.k }
+
+func (n *literalNode) eval(exprEnv) (exprValue, error) { return n.value, nil }
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) kind() exprKind { return exprNumber }
+
+func (n *variableNode) eval(env exprEnv) (exprValue, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return exprValue{}, fmt.Errorf("variable %q is not set", n.name)
+	}
+	return exprValue{num: v}, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) kind() exprKind { return n.operand.kind() }
+
+func (n *unaryNode) eval(env exprEnv) (exprValue, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return exprValue{}, err
+	}
+	if n.op == "!" {
+		return exprValue{boolean: !v.boolean}, nil
+	}
+	return exprValue{num: new(big.Rat).Neg(v.num)}, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) kind() exprKind {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return exprNumber
+	default:
+		return exprBool
+	}
+}
+
+func (n *binaryNode) eval(env exprEnv) (exprValue, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return exprValue{}, err
+	}
+	// && and || short circuit like in most languages
+	switch {
+	case n.op == "&&" && !l.boolean:
+		return exprValue{boolean: false}, nil
+	case n.op == "||" && l.boolean:
+		return exprValue{boolean: true}, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return exprValue{}, err
+	}
+	switch n.op {
+	case "&&", "||":
+		return exprValue{boolean: r.boolean}, nil
+	case "+":
+		return boundedNumber(new(big.Rat).Add(l.num, r.num))
+	case "-":
+		return boundedNumber(new(big.Rat).Sub(l.num, r.num))
+	case "*":
+		return boundedNumber(new(big.Rat).Mul(l.num, r.num))
+	case "/":
+		if r.num.Sign() == 0 {
+			return exprValue{}, errors.New("division by zero")
+		}
+		return boundedNumber(new(big.Rat).Quo(l.num, r.num))
+	}
+	c := l.num.Cmp(r.num)
+	switch n.op {
+	case "<":
+		return exprValue{boolean: c < 0}, nil
+	case "<=":
+		return exprValue{boolean: c <= 0}, nil
+	case ">":
+		return exprValue{boolean: c > 0}, nil
+	case ">=":
+		return exprValue{boolean: c >= 0}, nil
+	case "==":
+		return exprValue{boolean: c == 0}, nil
+	case "!=":
+		return exprValue{boolean: c != 0}, nil
+	default:
+		return exprValue{}, fmt.Errorf("unsupported operator %q", n.op)
+	}
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n *callNode) kind() exprKind { return exprNumber }
+
+func (n *callNode) eval(env exprEnv) (exprValue, error) {
+	args := make([]*big.Rat, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return exprValue{}, err
+		}
+		args[i] = v.num
+	}
+	switch n.name {
+	case "abs":
+		return exprValue{num: new(big.Rat).Abs(args[0])}, nil
+	case "min":
+		if args[0].Cmp(args[1]) <= 0 {
+			return exprValue{num: args[0]}, nil
+		}
+		return exprValue{num: args[1]}, nil
+	case "max":
+		if args[0].Cmp(args[1]) >= 0 {
+			return exprValue{num: args[0]}, nil
+		}
+		return exprValue{num: args[1]}, nil
+	case "ln":
+		if args[0].Sign() <= 0 {
+			return exprValue{}, errors.New("ln of a non-positive number")
+		}
+		r, _ := bigLn(newFloat().SetRat(args[0])).Rat(nil)
+		return boundedNumber(r)
+	case "sqrt":
+		if args[0].Sign() < 0 {
+			return exprValue{}, errors.New("sqrt of a negative number")
+		}
+		if args[0].Sign() == 0 {
+			return exprValue{num: new(big.Rat)}, nil
+		}
+		r, _ := newFloat().Sqrt(newFloat().SetRat(args[0])).Rat(nil)
+		return boundedNumber(r)
+	default:
+		return exprValue{}, fmt.Errorf("unsupported function %q", n.name)
+	}
+}
+
+// boundedNumber fails once a value grows past MaxExpressionValueBits, which
+// bounds the cost of every further operation on it.
+func boundedNumber(v *big.Rat) (exprValue, error) {
+	if v.Num().BitLen() > MaxExpressionValueBits || v.Denom().BitLen() > MaxExpressionValueBits {
+		return exprValue{}, fmt.Errorf("expression value exceeds %d bits", MaxExpressionValueBits)
+	}
+	return exprValue{num: v}, nil
+}
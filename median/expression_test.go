@@ -0,0 +1,83 @@
+package median
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CompileExpression(t *testing.T) {
+	variables := map[string]bool{"x": true, "y": true}
+	tcs := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "comparison", src: "x + 1 > y * 2"},
+		{name: "logic and functions", src: "!(abs(x - y) < 1) || min(x, y) >= max(ln(2), sqrt(2))"},
+		{name: "unknown identifier", src: "z > 1", err: `unknown identifier "z" at offset 0`},
+		{name: "unknown function", src: "exp(x) > 1", err: `unknown function "exp" at offset 0`},
+		{name: "wrong arity", src: "min(x) > 1", err: `function "min" at offset 0 takes 2 arguments, got 1`},
+		{name: "number result", src: "x + y", err: "expression must evaluate to a boolean, not a number"},
+		{name: "arithmetic on booleans", src: "(x > 1) + 1 > 0", err: `operator "+" at offset 8 requires number operands`},
+		{name: "logic on numbers", src: "x && y", err: `operator "&&" at offset 2 requires boolean operands`},
+		{name: "negated boolean", src: "-(x > 1)", err: `operator "-" at offset 0 cannot be applied to a boolean`},
+		{name: "chained comparison", src: "x < y < 1", err: `unexpected "<" at offset 6`},
+		{name: "unbalanced parenthesis", src: "(x > 1", err: `expected ")" at offset 6, got "end of expression"`},
+		{name: "exponent notation", src: "x > 1e9", err: `unexpected "e9" at offset 5`},
+		{name: "invalid character", src: "x > $1", err: `unexpected character '$' at offset 4`},
+		{name: "trailing dot", src: "x > 1.", err: "invalid number at offset 4"},
+		{name: "too long", src: "x > " + strings.Repeat("1", MaxExpressionLength), err: "expression exceeds 4096 characters"},
+		{name: "too many nodes", src: "x > " + strings.Repeat("1 + ", MaxExpressionNodes) + "1", err: "expression exceeds 256 nodes"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compileExpression(tc.src, variables, exprBool)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_ExpressionEval(t *testing.T) {
+	env := exprEnv{"x": big.NewRat(3, 1), "y": big.NewRat(-1, 2), "zero": new(big.Rat)}
+	tcs := []struct {
+		name     string
+		src      string
+		err      string
+		expected bool
+	}{
+		{name: "exact division", src: "1 / 3 * 3 == 1", expected: true},
+		{name: "precedence", src: "1 + 2 * 3 == 7", expected: true},
+		{name: "unary minus", src: "-y == 0.5", expected: true},
+		{name: "abs", src: "abs(y) == 0.5", expected: true},
+		{name: "min and max", src: "min(x, y) == y && max(x, y) == x", expected: true},
+		{name: "ln", src: "ln(x) > 1.0986122886 && ln(x) < 1.0986122887", expected: true},
+		{name: "sqrt", src: "sqrt(x) > 1.7320508075 && sqrt(x) < 1.7320508076", expected: true},
+		{name: "sqrt of zero", src: "sqrt(zero) == 0", expected: true},
+		{name: "short circuit skips division by zero", src: "x < 0 && x / zero > 1", expected: false},
+		{name: "division by zero", src: "x / zero > 1", err: "division by zero"},
+		{name: "ln of zero", src: "ln(zero) > 1", err: "ln of a non-positive number"},
+		{name: "sqrt of negative", src: "sqrt(y) > 1", err: "sqrt of a negative number"},
+		{name: "value too large", src: "x" + strings.Repeat(" * 1"+strings.Repeat("0", 99), 13) + " > 1", err: "expression value exceeds 4096 bits"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := compileExpression(tc.src, map[string]bool{"x": true, "y": true, "zero": true}, exprBool)
+			require.NoError(t, err)
+			v, err := expr.eval(env)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, v.boolean)
+			}
+		})
+	}
+}
@@ -2,11 +2,16 @@ package median
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"math/big"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/smartcontractkit/libocr/commontypes"
 	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
 	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
 
@@ -22,10 +27,148 @@ const contractName = "median"
 type Plugin struct {
 	loop.Plugin
 	stop services.StopChan
+
+	// decisionSink receives the deviation decisions of every feed, may be nil
+	decisionSink DecisionSink
+	// decisionHistory is the number of decisions kept per feed
+	decisionHistory int
+
+	feedsMu sync.Mutex
+	// feeds holds the state of every feed with an open factory, by contract ID
+	feeds map[string]*feedState
+}
+
+// feedState is what the plugin keeps about a feed while it has open factories.
+type feedState struct {
+	// factories counts the open factories of the feed, the state is dropped when none are left
+	factories       int
+	decisions       *DecisionRing
+	driftRejections *observerCounter
+}
+
+// PluginOption configures a [Plugin].
+type PluginOption func(*Plugin)
+
+// WithDecisionSink sends the deviation decisions of every feed to sink, in
+// addition to the debug log and the history kept per feed.
+func WithDecisionSink(sink DecisionSink) PluginOption {
+	return func(p *Plugin) {
+		p.decisionSink = sink
+	}
+}
+
+// WithDecisionHistory keeps the last n deviation decisions of every feed instead
+// of [DefaultDecisionHistory]. A feed keeps at least one.
+func WithDecisionHistory(n int) PluginOption {
+	return func(p *Plugin) {
+		p.decisionHistory = n
+	}
+}
+
+func NewPlugin(lggr logger.Logger, opts ...PluginOption) *Plugin {
+	p := &Plugin{Plugin: loop.Plugin{Logger: lggr}, stop: make(services.StopChan), feeds: map[string]*feedState{}, decisionHistory: DefaultDecisionHistory}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
-func NewPlugin(lggr logger.Logger) *Plugin {
-	return &Plugin{Plugin: loop.Plugin{Logger: lggr}, stop: make(services.StopChan)}
+// DeviationDecisions returns the last decisions made by the custom deviation
+// func of the feed contractID, oldest first, see [WithDecisionHistory]. The
+// history is dropped once the feed has no open factory.
+func (p *Plugin) DeviationDecisions(contractID string) []DeviationDecision {
+	p.feedsMu.Lock()
+	feed, ok := p.feeds[contractID]
+	p.feedsMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return feed.decisions.Decisions()
+}
+
+// DecisionsHandler serves [Plugin.DeviationDecisions] as JSON, since the plugin
+// runs in its own process and the node cannot call it. With a 'feed' query
+// parameter it returns the decisions of that feed, otherwise those of every
+// feed by contract ID.
+func (p *Plugin) DecisionsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body any
+		if feed := r.URL.Query().Get("feed"); feed != "" {
+			decisions := p.DeviationDecisions(feed)
+			if decisions == nil {
+				http.Error(w, "unknown feed", http.StatusNotFound)
+				return
+			}
+			body = decisions
+		} else {
+			p.feedsMu.Lock()
+			feeds := maps.Clone(p.feeds)
+			p.feedsMu.Unlock()
+			all := make(map[string][]DeviationDecision, len(feeds))
+			for contractID, feed := range feeds {
+				all[contractID] = feed.decisions.Decisions()
+			}
+			body = all
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			p.Logger.Errorw("Failed to write deviation decisions", "err", err)
+		}
+	})
+}
+
+// openFeed returns the state of the feed contractID for a new factory, which
+// must call closeFeed when it is closed. The state is kept while any factory of
+// the feed is open, so it survives a factory being replaced by a new one.
+func (p *Plugin) openFeed(contractID string) *feedState {
+	p.feedsMu.Lock()
+	defer p.feedsMu.Unlock()
+	feed, ok := p.feeds[contractID]
+	if !ok {
+		feed = &feedState{decisions: NewDecisionRing(p.decisionHistory), driftRejections: newObserverCounter()}
+		p.feeds[contractID] = feed
+	}
+	feed.factories++
+	return feed
+}
+
+// closeFeed releases the state returned by openFeed, dropping it once no factory of the feed is left.
+func (p *Plugin) closeFeed(contractID string) {
+	p.feedsMu.Lock()
+	defer p.feedsMu.Unlock()
+	feed, ok := p.feeds[contractID]
+	if !ok {
+		return
+	}
+	if feed.factories--; feed.factories <= 0 {
+		delete(p.feeds, contractID)
+	}
+}
+
+// decisionSinkFor returns the sink for the decisions of the feed contractID.
+func (p *Plugin) decisionSinkFor(lggr logger.Logger, contractID string, feed *feedState) DecisionSink {
+	sinks := DecisionSinks{LoggerDecisionSink{Logger: lggr}, feed.decisions}
+	if p.decisionSink != nil {
+		sinks = append(sinks, p.decisionSink)
+	}
+	return feedDecisionSink{feed: contractID, sink: sinks}
+}
+
+// TimestampDriftRejections returns, per oracle, how many observations of the feed
+// contractID were dropped because their timestamp drifted too far from the median.
+// The counts are dropped once the feed has no open factory.
+func (p *Plugin) TimestampDriftRejections(contractID string) map[commontypes.OracleID]uint64 {
+	p.feedsMu.Lock()
+	feed, ok := p.feeds[contractID]
+	p.feedsMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return feed.driftRejections.snapshot()
 }
 
 func (p *Plugin) NewMedianFactory(ctx context.Context, provider types.MedianProvider, contractID string, dataSource, juelsPerFeeCoin, gasPriceSubunits median.DataSource, errorLog loop.ErrorLog, deviationFuncDefinition map[string]any) (loop.ReportingPluginFactory, error) {
@@ -41,15 +184,45 @@ func (p *Plugin) NewMedianFactory(ctx context.Context, provider types.MedianProv
 
 	includeGasPriceSubunitsInObservation := !isZeroDataSource
 
+	// The configuration may select how reports are aggregated, filtered, timestamped and laid out, the rest defines the deviation func.
+	aggregator, deviationFuncDefinition, err := splitAggregatorConfig(deviationFuncDefinition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aggregator: %w", err)
+	}
+	outliers, deviationFuncDefinition, err := splitOutlierFilterConfig(deviationFuncDefinition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outlier filter: %w", err)
+	}
+	timestamps, deviationFuncDefinition, err := splitTimestampPolicyConfig(deviationFuncDefinition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create timestamp policy: %w", err)
+	}
+	_, hasReportVersion := deviationFuncDefinition[reportVersionKey]
+	reportVersion, deviationFuncDefinition, err := splitReportVersionConfig(deviationFuncDefinition)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOutlierFilterVersion(outliers, reportVersion); err != nil {
+		return nil, fmt.Errorf("failed to create outlier filter: %w", err)
+	}
+
 	var deviationFunc median.DeviationFunc
 	if len(deviationFuncDefinition) > 0 {
-		var err error
 		deviationFunc, err = NewDeviationFunc(lggr, deviationFuncDefinition)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create deviation function: %w", err)
 		}
 	}
 
+	// Released when the factory is closed, or right away if creating it fails.
+	feed := p.openFeed(contractID)
+	created := false
+	defer func() {
+		if !created {
+			p.closeFeed(contractID)
+		}
+	}()
+
 	factory := median.NumericalMedianFactory{
 		DataSource:                           dataSource,
 		JuelsPerFeeCoinDataSource:            juelsPerFeeCoin,
@@ -79,16 +252,38 @@ func (p *Plugin) NewMedianFactory(ctx context.Context, provider types.MedianProv
 	}
 
 	if codec := provider.Codec(); codec != nil {
-		factory.ReportCodec = &reportCodec{codec: codec}
+		factory.ReportCodec = &reportCodec{
+			codec:           codec,
+			version:         reportVersion,
+			aggregator:      aggregator,
+			outliers:        outliers,
+			timestamps:      timestamps,
+			driftRejections: feed.driftRejections,
+			lggr:            lggr,
+		}
 	} else {
+		if aggregator != nil || outliers != nil || timestamps != nil || hasReportVersion {
+			return nil, errors.New("an aggregator, outlier filter, timestamp policy or report version is configured but the provider has no codec to build reports with it")
+		}
 		lggr.Info("No codec provided, defaulting back to median specific ReportCodec")
 		factory.ReportCodec = provider.ReportCodec()
 	}
 
-	s := &reportingPluginFactoryService{lggr: logger.Named(lggr, "ReportingPluginFactory"), ReportingPluginFactory: factory}
+	var pluginFactory ocrtypes.ReportingPluginFactory = factory
+	if deviationFunc != nil {
+		if err := checkReportCodecSupport(deviationFuncDefinition, factory.ReportCodec); err != nil {
+			return nil, fmt.Errorf("failed to create deviation function: %w", err)
+		}
+		// Custom deviation funcs may need data about the round being evaluated, e.g. a deterministic timestamp.
+		factory.ContractTransmitter = &deviationContract{MedianContract: factory.ContractTransmitter}
+		pluginFactory = &deviationReportingPluginFactory{ReportingPluginFactory: factory, reportCodec: factory.ReportCodec, decisions: p.decisionSinkFor(lggr, contractID, feed)}
+	}
+
+	s := &reportingPluginFactoryService{lggr: logger.Named(lggr, "ReportingPluginFactory"), ReportingPluginFactory: pluginFactory, onClose: func() { p.closeFeed(contractID) }}
 
 	p.SubService(s)
 
+	created = true
 	return s, nil
 }
 
@@ -102,6 +297,9 @@ type reportingPluginFactoryService struct {
 	services.StateMachine
 	lggr logger.Logger
 	ocrtypes.ReportingPluginFactory
+	// onClose runs once when the service is closed, whether or not it was started, may be nil
+	onClose   func()
+	closeOnce sync.Once
 }
 
 func (r *reportingPluginFactoryService) Name() string { return r.lggr.Name() }
@@ -111,6 +309,11 @@ func (r *reportingPluginFactoryService) Start(ctx context.Context) error {
 }
 
 func (r *reportingPluginFactoryService) Close() error {
+	r.closeOnce.Do(func() {
+		if r.onClose != nil {
+			r.onClose()
+		}
+	})
 	return r.StopOnce("ReportingPluginFactory", func() error { return nil })
 }
 
@@ -2,42 +2,198 @@ package median
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"math/big"
 
 	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
 	ocrtypes "github.com/smartcontractkit/libocr/offchainreporting2plus/types"
 
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
 	"github.com/smartcontractkit/chainlink-common/pkg/types"
 )
 
-const typeName = "MedianReport"
+const (
+	typeName = "MedianReport"
+	// typeNameV2 is the codec type of version 2 reports, see [aggregatedAttributedObservationV2].
+	typeNameV2 = "MedianReportV2"
+	// typeNameV3 is the codec type of version 3 reports, see [aggregatedAttributedObservationV3].
+	typeNameV3 = "MedianReportV3"
+)
+
+// Report versions. Version 1 has a fixed size observers array of 32 oracles,
+// version 2 one observer per observation and a leading version field, version
+// 3 adds the spread, interquartile range and number of contributing oracles.
+const (
+	reportVersion1 uint8 = 1
+	reportVersion2 uint8 = 2
+	reportVersion3 uint8 = 3
+)
+
+// reportVersionKey is the field of the factory configuration selecting the
+// report version. It is removed before the rest of the configuration is read as
+// a deviation function definition.
+const reportVersionKey = "reportVersion"
 
 type reportCodec struct {
 	codec types.Codec
+	// version is the layout of built and decoded reports, version 1 if zero
+	version uint8
+	// aggregator computes the value of a report, the upper median if nil
+	aggregator Aggregator
+	// outliers rejects outlying observations before they are aggregated, nil to keep all
+	outliers *outlierFilter
+	// timestamps selects the report timestamp and drops drifting observations, nil to take the median
+	timestamps *timestampPolicy
+	// driftRejections counts the observations dropped by timestamps per observer, may be nil
+	driftRejections *observerCounter
+	lggr            logger.Logger
 }
 
 var _ median.ReportCodec = &reportCodec{}
+var _ reportTimestampReader = &reportCodec{}
+var _ reportFeeReader = &reportCodec{}
 
 func (r *reportCodec) BuildReport(ctx context.Context, observations []median.ParsedAttributedObservation) (ocrtypes.Report, error) {
 	if len(observations) == 0 {
 		return nil, fmt.Errorf("cannot build report from empty attributed observations")
 	}
 
-	return r.codec.Encode(ctx, aggregate(observations), typeName)
+	observations, dropped, err := r.timestamps.dropDrifted(observations)
+	if len(dropped) > 0 {
+		if r.driftRejections != nil {
+			r.driftRejections.add(dropped)
+		}
+		r.lggr.Warnw("Dropped observations with drifting timestamps", "observers", dropped, "dropped", len(dropped), "kept", len(observations), "maxDriftSeconds", r.timestamps.maxDriftSeconds)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := aggregate(observations, r.outliers, r.timestamps)
+	if err != nil {
+		return nil, err
+	}
+	// Fail here rather than build a report whose value cannot be read back.
+	if _, err := r.valueOf(agg); err != nil {
+		return nil, err
+	}
+	switch r.version {
+	case reportVersion3:
+		return r.codec.Encode(ctx, agg, typeNameV3)
+	case reportVersion2:
+		return r.codec.Encode(ctx, agg.toV2(), typeNameV2)
+	}
+	v1, err := agg.toV1()
+	if err != nil {
+		return nil, err
+	}
+	return r.codec.Encode(ctx, v1, typeName)
 }
 
-func (r *reportCodec) MedianFromReport(ctx context.Context, report ocrtypes.Report) (*big.Int, error) {
+// decode reads report in the version of the codec, returning it in the version 3 layout.
+func (r *reportCodec) decode(ctx context.Context, report ocrtypes.Report) (*aggregatedAttributedObservationV3, error) {
+	switch r.version {
+	case reportVersion3:
+		agg := &aggregatedAttributedObservationV3{}
+		if err := r.codec.Decode(ctx, report, agg, typeNameV3); err != nil {
+			return nil, err
+		}
+		if agg.Version != reportVersion3 {
+			return nil, fmt.Errorf("expected report version %d, got %d", reportVersion3, agg.Version)
+		}
+		return agg, nil
+	case reportVersion2:
+		agg := &aggregatedAttributedObservationV2{}
+		if err := r.codec.Decode(ctx, report, agg, typeNameV2); err != nil {
+			return nil, err
+		}
+		if agg.Version != reportVersion2 {
+			return nil, fmt.Errorf("expected report version %d, got %d", reportVersion2, agg.Version)
+		}
+		return agg.toV3(), nil
+	}
 	agg := &aggregatedAttributedObservation{}
 	if err := r.codec.Decode(ctx, report, agg, typeName); err != nil {
 		return nil, err
 	}
-	observations := make([]*big.Int, len(agg.Observations))
-	copy(observations, agg.Observations)
-	medianObservation := len(agg.Observations) / 2
-	return agg.Observations[medianObservation], nil
+	return agg.toV3()
 }
 
+// MedianFromReport returns the value of the report as computed by the
+// aggregator of the codec, which is the median unless configured otherwise.
+func (r *reportCodec) MedianFromReport(ctx context.Context, report ocrtypes.Report) (*big.Int, error) {
+	agg, err := r.decode(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	return r.valueOf(agg)
+}
+
+func (r *reportCodec) valueOf(agg *aggregatedAttributedObservationV3) (*big.Int, error) {
+	if len(agg.Observations) == 0 {
+		return nil, errors.New("report has no observations")
+	}
+	if len(agg.Observers) != len(agg.Observations) {
+		return nil, fmt.Errorf("report has %d observers for %d observations", len(agg.Observers), len(agg.Observations))
+	}
+	aggregator := r.aggregator
+	if aggregator == nil {
+		aggregator = MedianAggregator{}
+	}
+	return aggregator.Aggregate(agg.Observations, agg.Observers)
+}
+
+func (r *reportCodec) TimestampFromReport(ctx context.Context, report ocrtypes.Report) (uint32, error) {
+	agg, err := r.decode(ctx, report)
+	if err != nil {
+		return 0, err
+	}
+	return agg.Timestamp, nil
+}
+
+func (r *reportCodec) FeesFromReport(ctx context.Context, report ocrtypes.Report) (*big.Int, *big.Int, error) {
+	agg, err := r.decode(ctx, report)
+	if err != nil {
+		return nil, nil, err
+	}
+	return agg.JuelsPerFeeCoin, agg.GasPriceSubunit, nil
+}
+
+// MaxReportLength returns the largest report for n oracles. Every slice of a
+// report has at most n entries, which is what the codec assumes for n. Version
+// 1 reports cannot hold more than 32 oracles, so larger n is an error.
 func (r *reportCodec) MaxReportLength(ctx context.Context, n int) (int, error) {
+	switch r.version {
+	case reportVersion3:
+		return r.codec.GetMaxDecodingSize(ctx, n, typeNameV3)
+	case reportVersion2:
+		return r.codec.GetMaxDecodingSize(ctx, n, typeNameV2)
+	}
+	if n > maxObserversV1 {
+		return 0, fmt.Errorf("report version 1 supports at most %d oracles, got %d, use report version 2", maxObserversV1, n)
+	}
 	return r.codec.GetMaxDecodingSize(ctx, n, typeName)
 }
+
+// splitReportVersionConfig returns the report version selected by the factory
+// configuration cfg, version 1 if none is, and cfg without it.
+func splitReportVersionConfig(cfg map[string]any) (uint8, map[string]any, error) {
+	raw, ok := cfg[reportVersionKey]
+	if !ok {
+		return reportVersion1, cfg, nil
+	}
+	rest := maps.Clone(cfg)
+	delete(rest, reportVersionKey)
+	switch v, _ := raw.(float64); v {
+	case float64(reportVersion1):
+		return reportVersion1, rest, nil
+	case float64(reportVersion2):
+		return reportVersion2, rest, nil
+	case float64(reportVersion3):
+		return reportVersion3, rest, nil
+	default:
+		return 0, nil, fmt.Errorf("invalid 'reportVersion' field in factory configuration: %v", raw)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"math/big"
+	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/smartcontractkit/libocr/commontypes"
@@ -11,7 +13,9 @@ import (
 	"github.com/smartcontractkit/libocr/offchainreporting2plus/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
 	"github.com/smartcontractkit/chainlink-common/pkg/utils/tests"
 )
 
@@ -89,7 +93,7 @@ func TestReportCodec(t *testing.T) {
 
 	t.Run("BuildReport returns error if codec returns error", func(t *testing.T) {
 		rc := reportCodec{
-			&testCodec{
+			codec: &testCodec{
 				t:        t,
 				expected: &aggReports,
 				result:   anyEncodedReport,
@@ -103,7 +107,7 @@ func TestReportCodec(t *testing.T) {
 
 	t.Run("MedianFromReport delegates to codec and gets the median", func(t *testing.T) {
 		rc := reportCodec{
-			&testCodec{
+			codec: &testCodec{
 				t:        t,
 				expected: anyEncodedReport,
 				result:   aggReports,
@@ -115,9 +119,163 @@ func TestReportCodec(t *testing.T) {
 		assert.Equal(t, big.NewInt(250), medianVal)
 	})
 
+	withOutlier := append(slices.Clone(anyReports), median.ParsedAttributedObservation{
+		Timestamp:        124,
+		Value:            big.NewInt(1_000_000),
+		JuelsPerFeeCoin:  big.NewInt(100),
+		GasPriceSubunits: big.NewInt(1),
+		Observer:         3,
+	})
+
+	t.Run("BuildReport returns error if version 1 cannot record the observers of outliers", func(t *testing.T) {
+		rc := reportCodec{
+			codec:    &testCodec{t: t},
+			outliers: &outlierFilter{k: big.NewRat(3, 1)},
+		}
+
+		_, err := rc.BuildReport(tests.Context(t), withOutlier)
+		require.EqualError(t, err, "report version 1 cannot record rejected observers, got 1")
+	})
+
+	t.Run("BuildReport records the observers of outliers in version 2", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t: t,
+				expected: &aggregatedAttributedObservationV2{
+					Version:           reportVersion2,
+					Timestamp:         aggReports.Timestamp,
+					Observers:         aggReports.Observers[:3],
+					Observations:      aggReports.Observations,
+					JuelsPerFeeCoin:   aggReports.JuelsPerFeeCoin,
+					GasPriceSubunit:   aggReports.GasPriceSubunit,
+					RejectedObservers: []commontypes.OracleID{3},
+				},
+				result:   anyEncodedReport,
+				itemType: typeNameV2,
+			},
+			version:  reportVersion2,
+			outliers: &outlierFilter{k: big.NewRat(3, 1)},
+		}
+
+		encoded, err := rc.BuildReport(tests.Context(t), withOutlier)
+		require.NoError(t, err)
+		assert.Equal(t, types.Report(anyEncodedReport), encoded)
+	})
+
+	t.Run("BuildReport returns error if too few observations are not outliers", func(t *testing.T) {
+		rc := reportCodec{
+			codec:    &testCodec{t: t},
+			outliers: &outlierFilter{k: big.NewRat(1, 10), minObservations: 3},
+		}
+
+		_, err := rc.BuildReport(tests.Context(t), anyReports)
+		require.EqualError(t, err, "only 1 of 3 observations are not outliers, need at least 3")
+	})
+
+	t.Run("BuildReport drops, logs and counts drifting timestamps", func(t *testing.T) {
+		skewed := append(slices.Clone(anyReports), median.ParsedAttributedObservation{
+			Timestamp:        3600,
+			Value:            big.NewInt(1_000_000),
+			JuelsPerFeeCoin:  big.NewInt(100),
+			GasPriceSubunits: big.NewInt(1),
+			Observer:         3,
+		})
+		lggr, logs := logger.TestObserved(t, zapcore.WarnLevel)
+		counter := newObserverCounter()
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: &aggReports,
+				result:   anyEncodedReport,
+			},
+			timestamps:      &timestampPolicy{method: TimestampMedian, maxDriftSeconds: 60},
+			driftRejections: counter,
+			lggr:            lggr,
+		}
+
+		for i := 0; i < 2; i++ {
+			_, err := rc.BuildReport(tests.Context(t), skewed)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, map[commontypes.OracleID]uint64{3: 2}, counter.snapshot())
+		entries := logs.FilterMessage("Dropped observations with drifting timestamps").All()
+		require.Len(t, entries, 2)
+		assert.Equal(t, int64(1), entries[0].ContextMap()["dropped"])
+	})
+
+	t.Run("BuildReport takes the timestamp chosen by the policy", func(t *testing.T) {
+		expected := aggReports
+		expected.Timestamp = 125
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: &expected,
+				result:   anyEncodedReport,
+			},
+			timestamps: &timestampPolicy{method: TimestampMax},
+		}
+
+		_, err := rc.BuildReport(tests.Context(t), anyReports)
+		require.NoError(t, err)
+	})
+
+	t.Run("MedianFromReport uses the aggregator of the codec", func(t *testing.T) {
+		// The median is 250, the mean 483
+		skewed := aggReports
+		skewed.Observations = []*big.Int{big.NewInt(200), big.NewInt(250), big.NewInt(1000)}
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   skewed,
+			},
+		}
+
+		value, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(250), value)
+
+		rc.aggregator = TrimmedMeanAggregator{}
+		value, err = rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(483), value)
+
+		rc.aggregator = WeightedMedianAggregator{Weights: map[commontypes.OracleID]uint64{1: 5}, DefaultWeight: 1}
+		value, err = rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(200), value)
+	})
+
+	t.Run("BuildReport returns error if the aggregator cannot compute a value", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: &aggReports,
+				result:   anyEncodedReport,
+			},
+			aggregator: WeightedMedianAggregator{},
+		}
+
+		_, err := rc.BuildReport(tests.Context(t), anyReports)
+		require.EqualError(t, err, "total weight of observers is zero")
+	})
+
+	t.Run("MedianFromReport returns error if the report has no observations", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   aggregatedAttributedObservation{},
+			},
+		}
+
+		_, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.EqualError(t, err, "report has no observations")
+	})
+
 	t.Run("MedianFromReport returns error if codec returns error", func(t *testing.T) {
 		rc := reportCodec{
-			&testCodec{
+			codec: &testCodec{
 				t:        t,
 				expected: anyEncodedReport,
 				result:   aggReports,
@@ -133,7 +291,7 @@ func TestReportCodec(t *testing.T) {
 	anyLen := 200
 	t.Run("MaxReportLength delegates to codec", func(t *testing.T) {
 		rc := reportCodec{
-			&testCodec{
+			codec: &testCodec{
 				t:        t,
 				expected: anyN,
 				result:   anyLen,
@@ -146,7 +304,7 @@ func TestReportCodec(t *testing.T) {
 	})
 
 	t.Run("MaxReportLength returns error if codec returns error", func(t *testing.T) {
-		rc := reportCodec{&testCodec{
+		rc := reportCodec{codec: &testCodec{
 			t:        t,
 			expected: 10,
 			result:   anyLen,
@@ -164,30 +322,304 @@ type testCodec struct {
 	expected any
 	result   any
 	err      error
+	// itemType is the expected codec type, typeName if empty
+	itemType string
+}
+
+func (t *testCodec) expectedItemType() string {
+	if t.itemType == "" {
+		return typeName
+	}
+	return t.itemType
 }
 
 func (t *testCodec) Encode(_ context.Context, item any, itemType string) ([]byte, error) {
 	assert.Equal(t.t, t.expected, item)
-	assert.Equal(t.t, typeName, itemType)
+	assert.Equal(t.t, t.expectedItemType(), itemType)
 	return t.result.([]byte), t.err
 }
 
 func (t *testCodec) GetMaxEncodingSize(_ context.Context, n int, itemType string) (int, error) {
 	assert.Equal(t.t, t.expected, n)
-	assert.Equal(t.t, typeName, itemType)
+	assert.Equal(t.t, t.expectedItemType(), itemType)
 	return t.result.(int), t.err
 }
 
 func (t *testCodec) Decode(_ context.Context, raw []byte, into any, itemType string) error {
 	assert.Equal(t.t, t.expected, raw)
-	assert.Equal(t.t, typeName, itemType)
-	set := into.(*aggregatedAttributedObservation)
-	*set = t.result.(aggregatedAttributedObservation)
+	assert.Equal(t.t, t.expectedItemType(), itemType)
+	reflect.ValueOf(into).Elem().Set(reflect.ValueOf(t.result))
 	return t.err
 }
 
 func (t *testCodec) GetMaxDecodingSize(_ context.Context, n int, itemType string) (int, error) {
 	assert.Equal(t.t, t.expected, n)
-	assert.Equal(t.t, typeName, itemType)
+	assert.Equal(t.t, t.expectedItemType(), itemType)
 	return t.result.(int), t.err
 }
+
+func TestReportCodec_V2(t *testing.T) {
+	observations := make([]median.ParsedAttributedObservation, 40)
+	expected := aggregatedAttributedObservationV2{
+		Version:         reportVersion2,
+		Timestamp:       120,
+		Observers:       make([]commontypes.OracleID, 40),
+		Observations:    make([]*big.Int, 40),
+		JuelsPerFeeCoin: big.NewInt(1),
+		GasPriceSubunit: big.NewInt(2),
+	}
+	for i := range observations {
+		observations[i] = median.ParsedAttributedObservation{
+			Timestamp:        uint32(100 + i),
+			Value:            big.NewInt(int64(1000 - i)),
+			JuelsPerFeeCoin:  big.NewInt(1),
+			GasPriceSubunits: big.NewInt(2),
+			Observer:         commontypes.OracleID(i),
+		}
+		// Sorted by value, so the last observer comes first
+		expected.Observers[i] = commontypes.OracleID(39 - i)
+		expected.Observations[i] = big.NewInt(int64(961 + i))
+	}
+	anyEncodedReport := []byte{5, 6, 7, 8}
+
+	t.Run("BuildReport returns error instead of panicking with more than 32 observations in version 1", func(t *testing.T) {
+		rc := reportCodec{codec: &testCodec{t: t}}
+
+		_, err := rc.BuildReport(tests.Context(t), observations)
+		require.EqualError(t, err, "report version 1 supports at most 32 observations, got 40")
+	})
+
+	t.Run("BuildReport encodes every observer in version 2", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: &expected,
+				result:   anyEncodedReport,
+				itemType: typeNameV2,
+			},
+			version: reportVersion2,
+		}
+
+		encoded, err := rc.BuildReport(tests.Context(t), observations)
+		require.NoError(t, err)
+		assert.Equal(t, types.Report(anyEncodedReport), encoded)
+	})
+
+	t.Run("MedianFromReport and TimestampFromReport decode version 2", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   expected,
+				itemType: typeNameV2,
+			},
+			version: reportVersion2,
+		}
+
+		value, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(981), value)
+		timestamp, err := rc.TimestampFromReport(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(120), timestamp)
+	})
+
+	t.Run("MedianFromReport rejects an unexpected version", func(t *testing.T) {
+		wrongVersion := expected
+		wrongVersion.Version = 3
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   wrongVersion,
+				itemType: typeNameV2,
+			},
+			version: reportVersion2,
+		}
+
+		_, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.EqualError(t, err, "expected report version 2, got 3")
+	})
+
+	t.Run("MedianFromReport rejects mismatched observers", func(t *testing.T) {
+		mismatched := expected
+		mismatched.Observers = mismatched.Observers[:39]
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   mismatched,
+				itemType: typeNameV2,
+			},
+			version: reportVersion2,
+		}
+
+		_, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.EqualError(t, err, "report has 39 observers for 40 observations")
+	})
+
+	t.Run("MaxReportLength", func(t *testing.T) {
+		rc := reportCodec{codec: &testCodec{t: t}}
+		_, err := rc.MaxReportLength(tests.Context(t), 33)
+		require.EqualError(t, err, "report version 1 supports at most 32 oracles, got 33, use report version 2")
+
+		rc = reportCodec{
+			codec:   &testCodec{t: t, expected: 100, result: 4000, itemType: typeNameV2},
+			version: reportVersion2,
+		}
+		length, err := rc.MaxReportLength(tests.Context(t), 100)
+		require.NoError(t, err)
+		assert.Equal(t, 4000, length)
+	})
+}
+
+func TestReportCodec_V3(t *testing.T) {
+	observations := make([]median.ParsedAttributedObservation, 8)
+	expected := aggregatedAttributedObservationV3{
+		Version:            reportVersion3,
+		Timestamp:          104,
+		Observers:          make([]commontypes.OracleID, 8),
+		Observations:       make([]*big.Int, 8),
+		JuelsPerFeeCoin:    big.NewInt(1),
+		GasPriceSubunit:    big.NewInt(2),
+		Spread:             big.NewInt(70),
+		InterquartileRange: big.NewInt(40),
+		Contributors:       8,
+	}
+	for i := range observations {
+		observations[i] = median.ParsedAttributedObservation{
+			Timestamp:        uint32(100 + i),
+			Value:            big.NewInt(int64(1000 + 10*i)),
+			JuelsPerFeeCoin:  big.NewInt(1),
+			GasPriceSubunits: big.NewInt(2),
+			Observer:         commontypes.OracleID(i),
+		}
+		expected.Observers[i] = commontypes.OracleID(i)
+		expected.Observations[i] = big.NewInt(int64(1000 + 10*i))
+	}
+	anyEncodedReport := []byte{5, 6, 7, 8}
+
+	t.Run("BuildReport encodes the dispersion in version 3", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: &expected,
+				result:   anyEncodedReport,
+				itemType: typeNameV3,
+			},
+			version: reportVersion3,
+		}
+
+		encoded, err := rc.BuildReport(tests.Context(t), observations)
+		require.NoError(t, err)
+		assert.Equal(t, types.Report(anyEncodedReport), encoded)
+	})
+
+	t.Run("BuildReport leaves the dispersion out of version 2", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t: t,
+				expected: &aggregatedAttributedObservationV2{
+					Version:         reportVersion2,
+					Timestamp:       expected.Timestamp,
+					Observers:       expected.Observers,
+					Observations:    expected.Observations,
+					JuelsPerFeeCoin: expected.JuelsPerFeeCoin,
+					GasPriceSubunit: expected.GasPriceSubunit,
+				},
+				result:   anyEncodedReport,
+				itemType: typeNameV2,
+			},
+			version: reportVersion2,
+		}
+
+		_, err := rc.BuildReport(tests.Context(t), observations)
+		require.NoError(t, err)
+	})
+
+	t.Run("decode round trips version 3", func(t *testing.T) {
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   expected,
+				itemType: typeNameV3,
+			},
+			version: reportVersion3,
+		}
+
+		agg, err := rc.decode(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, &expected, agg)
+		value, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(1040), value)
+	})
+
+	t.Run("decode computes the dispersion of older versions", func(t *testing.T) {
+		v2 := expected.toV2()
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   *v2,
+				itemType: typeNameV2,
+			},
+			version: reportVersion2,
+		}
+
+		agg, err := rc.decode(tests.Context(t), anyEncodedReport)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(70), agg.Spread)
+		assert.Equal(t, big.NewInt(40), agg.InterquartileRange)
+		assert.Equal(t, uint32(8), agg.Contributors)
+	})
+
+	t.Run("MedianFromReport rejects an unexpected version", func(t *testing.T) {
+		wrongVersion := expected
+		wrongVersion.Version = reportVersion2
+		rc := reportCodec{
+			codec: &testCodec{
+				t:        t,
+				expected: anyEncodedReport,
+				result:   wrongVersion,
+				itemType: typeNameV3,
+			},
+			version: reportVersion3,
+		}
+
+		_, err := rc.MedianFromReport(tests.Context(t), anyEncodedReport)
+		require.EqualError(t, err, "expected report version 3, got 2")
+	})
+
+	t.Run("MaxReportLength", func(t *testing.T) {
+		rc := reportCodec{
+			codec:   &testCodec{t: t, expected: 100, result: 4100, itemType: typeNameV3},
+			version: reportVersion3,
+		}
+		length, err := rc.MaxReportLength(tests.Context(t), 100)
+		require.NoError(t, err)
+		assert.Equal(t, 4100, length)
+	})
+}
+
+func Test_splitReportVersionConfig(t *testing.T) {
+	version, rest, err := splitReportVersionConfig(map[string]any{"type": "relative"})
+	require.NoError(t, err)
+	assert.Equal(t, reportVersion1, version)
+	assert.Equal(t, map[string]any{"type": "relative"}, rest)
+
+	version, rest, err = splitReportVersionConfig(map[string]any{"type": "relative", "reportVersion": float64(2)})
+	require.NoError(t, err)
+	assert.Equal(t, reportVersion2, version)
+	assert.Equal(t, map[string]any{"type": "relative"}, rest)
+
+	version, _, err = splitReportVersionConfig(map[string]any{"reportVersion": float64(3)})
+	require.NoError(t, err)
+	assert.Equal(t, reportVersion3, version)
+
+	_, _, err = splitReportVersionConfig(map[string]any{"reportVersion": float64(4)})
+	require.EqualError(t, err, "invalid 'reportVersion' field in factory configuration: 4")
+	_, _, err = splitReportVersionConfig(map[string]any{"reportVersion": "2"})
+	require.EqualError(t, err, "invalid 'reportVersion' field in factory configuration: 2")
+}
@@ -0,0 +1,158 @@
+package median
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+)
+
+// timestampPolicyKey is the field of the factory configuration selecting how
+// the report timestamp is aggregated. It is removed before the rest of the
+// configuration is read as a deviation function definition.
+const timestampPolicyKey = "timestampPolicy"
+
+// MaxTimestampDriftSeconds bounds the 'maxDriftSeconds' field of the timestamp policy.
+const MaxTimestampDriftSeconds = 24 * 60 * 60
+
+// TimestampMethod selects which observation timestamp becomes the report timestamp.
+type TimestampMethod string
+
+const (
+	// TimestampMedian takes the upper median, as libocr does.
+	TimestampMedian TimestampMethod = "median"
+	// TimestampMax takes the latest timestamp.
+	TimestampMax TimestampMethod = "max"
+	// TimestampLowerQuartile takes the timestamp at index len/4, so a quarter of
+	// the oracles must have observed by then.
+	TimestampLowerQuartile TimestampMethod = "lowerQuartile"
+)
+
+type timestampPolicy struct {
+	method TimestampMethod
+	// maxDriftSeconds is how far a timestamp may be from the median one, 0 to keep all
+	maxDriftSeconds uint32
+}
+
+// pick returns the report timestamp of observations sorted by timestamp. A nil policy takes the median.
+func (p *timestampPolicy) pick(sorted []median.ParsedAttributedObservation) uint32 {
+	method := TimestampMedian
+	if p != nil {
+		method = p.method
+	}
+	switch method {
+	case TimestampMax:
+		return sorted[len(sorted)-1].Timestamp
+	case TimestampLowerQuartile:
+		return sorted[len(sorted)/4].Timestamp
+	default:
+		return sorted[len(sorted)/2].Timestamp
+	}
+}
+
+// dropDrifted returns the observations whose timestamp is within maxDriftSeconds
+// of the upper median timestamp, and the observers of the rest in ascending order.
+// It fails unless a majority of the observations is kept, still returning both.
+func (p *timestampPolicy) dropDrifted(observations []median.ParsedAttributedObservation) ([]median.ParsedAttributedObservation, []commontypes.OracleID, error) {
+	if p == nil || p.maxDriftSeconds == 0 || len(observations) == 0 {
+		return observations, nil, nil
+	}
+	timestamps := make([]uint32, len(observations))
+	for i, o := range observations {
+		timestamps[i] = o.Timestamp
+	}
+	slices.Sort(timestamps)
+	center := int64(timestamps[len(timestamps)/2])
+
+	var kept []median.ParsedAttributedObservation
+	var dropped []commontypes.OracleID
+	for _, o := range observations {
+		drift := int64(o.Timestamp) - center
+		if drift < 0 {
+			drift = -drift
+		}
+		if drift > int64(p.maxDriftSeconds) {
+			dropped = append(dropped, o.Observer)
+		} else {
+			kept = append(kept, o)
+		}
+	}
+	slices.Sort(dropped)
+	if need := len(observations)/2 + 1; len(kept) < need {
+		return kept, dropped, fmt.Errorf("only %d of %d observations have a timestamp within %d seconds of the median, need at least %d", len(kept), len(observations), p.maxDriftSeconds, need)
+	}
+	return kept, dropped, nil
+}
+
+func newTimestampPolicy(opts map[string]any) (*timestampPolicy, error) {
+	p := &timestampPolicy{method: TimestampMedian}
+	if v, ok := opts["method"]; ok {
+		method, _ := v.(string)
+		switch p.method = TimestampMethod(method); p.method {
+		case TimestampMedian, TimestampMax, TimestampLowerQuartile:
+		default:
+			return nil, fmt.Errorf("invalid 'method' field in timestamp policy definition: %v", v)
+		}
+	}
+	if _, ok := opts["maxDriftSeconds"]; ok {
+		v, err := integerOption(opts, "maxDriftSeconds")
+		if err != nil || v > MaxTimestampDriftSeconds {
+			return nil, fmt.Errorf("missing or invalid 'maxDriftSeconds' field in timestamp policy definition, must be an integer between 0 and %d", MaxTimestampDriftSeconds)
+		}
+		p.maxDriftSeconds = uint32(v)
+	}
+	for _, key := range slices.Sorted(maps.Keys(opts)) {
+		if key != "method" && key != "maxDriftSeconds" {
+			return nil, fmt.Errorf("unknown field '%s' in timestamp policy definition", key)
+		}
+	}
+	return p, nil
+}
+
+// splitTimestampPolicyConfig returns the timestamp policy selected by the
+// factory configuration cfg, nil if none is, and cfg without it.
+func splitTimestampPolicyConfig(cfg map[string]any) (*timestampPolicy, map[string]any, error) {
+	raw, ok := cfg[timestampPolicyKey]
+	if !ok {
+		return nil, cfg, nil
+	}
+	rest := maps.Clone(cfg)
+	delete(rest, timestampPolicyKey)
+	opts, ok := raw.(map[string]any)
+	if !ok {
+		return nil, nil, errors.New("missing or invalid 'timestampPolicy' field in factory configuration")
+	}
+	p, err := newTimestampPolicy(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, rest, nil
+}
+
+// observerCounter counts events per oracle. It is safe for concurrent use.
+type observerCounter struct {
+	mu     sync.Mutex
+	counts map[commontypes.OracleID]uint64
+}
+
+func newObserverCounter() *observerCounter {
+	return &observerCounter{counts: map[commontypes.OracleID]uint64{}}
+}
+
+func (c *observerCounter) add(observers []commontypes.OracleID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, o := range observers {
+		c.counts[o]++
+	}
+}
+
+func (c *observerCounter) snapshot() map[commontypes.OracleID]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.counts)
+}
@@ -0,0 +1,150 @@
+package median
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/smartcontractkit/libocr/commontypes"
+	"github.com/smartcontractkit/libocr/offchainreporting2/reportingplugin/median"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func observationsAt(timestamps ...uint32) []median.ParsedAttributedObservation {
+	paos := make([]median.ParsedAttributedObservation, len(timestamps))
+	for i, ts := range timestamps {
+		paos[i] = median.ParsedAttributedObservation{
+			Timestamp:        ts,
+			Value:            big.NewInt(int64(i)),
+			JuelsPerFeeCoin:  big.NewInt(1),
+			GasPriceSubunits: big.NewInt(1),
+			Observer:         commontypes.OracleID(i),
+		}
+	}
+	return paos
+}
+
+func Test_timestampPolicy_pick(t *testing.T) {
+	sorted := observationsAt(100, 101, 102, 103, 104, 105, 106, 107)
+	tcs := []struct {
+		name     string
+		policy   *timestampPolicy
+		expected uint32
+	}{
+		{name: "nil policy takes the median", expected: 104},
+		{name: "median", policy: &timestampPolicy{method: TimestampMedian}, expected: 104},
+		{name: "max", policy: &timestampPolicy{method: TimestampMax}, expected: 107},
+		{name: "lower quartile", policy: &timestampPolicy{method: TimestampLowerQuartile}, expected: 102},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.policy.pick(sorted))
+		})
+	}
+}
+
+func Test_timestampPolicy_dropDrifted(t *testing.T) {
+	tcs := []struct {
+		name         string
+		policy       *timestampPolicy
+		observations []median.ParsedAttributedObservation
+
+		err     string
+		kept    int
+		dropped []commontypes.OracleID
+	}{
+		{
+			name:         "nil policy keeps everything",
+			observations: observationsAt(100, 5000, 1),
+			kept:         3,
+		},
+		{
+			name:         "no tolerance keeps everything",
+			policy:       &timestampPolicy{method: TimestampMax},
+			observations: observationsAt(100, 5000, 1),
+			kept:         3,
+		},
+		{
+			name:         "drops stale and skewed clocks",
+			policy:       &timestampPolicy{maxDriftSeconds: 10},
+			observations: observationsAt(100, 3600, 105, 98, 110, 0),
+			kept:         4,
+			dropped:      []commontypes.OracleID{1, 5},
+		},
+		{
+			name:         "drift at the tolerance is kept",
+			policy:       &timestampPolicy{maxDriftSeconds: 10},
+			observations: observationsAt(100, 90, 110),
+			kept:         3,
+		},
+		{
+			name:         "fails without a majority",
+			policy:       &timestampPolicy{maxDriftSeconds: 10},
+			observations: observationsAt(100, 200, 300, 400),
+			err:          "only 1 of 4 observations have a timestamp within 10 seconds of the median, need at least 3",
+			kept:         1,
+			dropped:      []commontypes.OracleID{0, 1, 3},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			kept, dropped, err := tc.policy.dropDrifted(tc.observations)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Len(t, kept, tc.kept)
+			assert.Equal(t, tc.dropped, dropped)
+		})
+	}
+}
+
+func Test_newTimestampPolicy(t *testing.T) {
+	tcs := []struct {
+		name     string
+		opts     map[string]any
+		err      string
+		expected *timestampPolicy
+	}{
+		{name: "defaults", opts: map[string]any{}, expected: &timestampPolicy{method: TimestampMedian}},
+		{
+			name:     "lower quartile with drift",
+			opts:     map[string]any{"method": "lowerQuartile", "maxDriftSeconds": float64(30)},
+			expected: &timestampPolicy{method: TimestampLowerQuartile, maxDriftSeconds: 30},
+		},
+		{name: "unknown method", opts: map[string]any{"method": "min"}, err: "invalid 'method' field in timestamp policy definition: min"},
+		{
+			name: "negative drift",
+			opts: map[string]any{"maxDriftSeconds": float64(-1)},
+			err:  "missing or invalid 'maxDriftSeconds' field in timestamp policy definition, must be an integer between 0 and 86400",
+		},
+		{
+			name: "drift too large",
+			opts: map[string]any{"maxDriftSeconds": float64(86401)},
+			err:  "missing or invalid 'maxDriftSeconds' field in timestamp policy definition, must be an integer between 0 and 86400",
+		},
+		{name: "unknown field", opts: map[string]any{"maxDrift": float64(1)}, err: "unknown field 'maxDrift' in timestamp policy definition"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := newTimestampPolicy(tc.opts)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	t.Run("split from the factory configuration", func(t *testing.T) {
+		p, rest, err := splitTimestampPolicyConfig(map[string]any{"type": "relative", "timestampPolicy": map[string]any{"method": "max"}})
+		require.NoError(t, err)
+		assert.Equal(t, &timestampPolicy{method: TimestampMax}, p)
+		assert.Equal(t, map[string]any{"type": "relative"}, rest)
+
+		_, _, err = splitTimestampPolicyConfig(map[string]any{"timestampPolicy": "max"})
+		require.EqualError(t, err, "missing or invalid 'timestampPolicy' field in factory configuration")
+	})
+}